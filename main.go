@@ -2,378 +2,5695 @@ package main
 
 import (
 	"bytes"
+	"context"
     "crypto/tls"
     "crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
     "strings"
+	"text/template"
+	"time"
 
+	"github.com/robertkrimen/otto"
 	"github.com/sensu/sensu-go/types"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
-	checkLabels  string
-	entityLabels string
-	namespaces   string
-    apiProto     string
-	apiHost      string
-	apiPort      string
-	apiUser      string
-	apiPass      string
-    caPath       string
-	warnPercent  int
-	critPercent  int
-	warnCount    int
-	critCount    int
+	version = "dev"
+	commit  = "dev"
+	date    = "dev"
 )
 
+const backendAnnotation = "sensu-aggregate-check/backend"
+
+const graphqlLimit = 1000
+
+const histogramWidth = 20
+
+// The delay before retrying a failed POST doubles from webhookBackoff up to
+// webhookMaxBackoff.
+const (
+	webhookBackoff    = 100 * time.Millisecond
+	webhookMaxBackoff = 5 * time.Second
+)
+
+var statusNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// presets are the flag values set by --preset, unless set explicitly.
+var presets = map[string]map[string]string{
+	"keepalives": {
+		"check-labels":   "",
+		"match-all":      "true",
+		"include-checks": "keepalive",
+	},
+	"critical-only": {
+		"ok-statuses": "1,3",
+	},
+	"by-team": {
+		"namespace-label": "team",
+		"by-namespace":    "true",
+	},
+}
+
+var (
+	checkLabels           string
+	entityLabels          string
+	ignoreAnnotation      string
+	latestOnly            bool
+	eventState            string
+	labelKeyFold          bool
+	labelValueFold        bool
+	checkSelector         map[string]string
+	entitySelector        map[string]string
+	ignoreSelector        map[string]string
+	namespaceSelector     map[string]string
+	matchAll              bool
+	preset                string
+	entityPlatform        string
+	entityArch            string
+	entityNameExpr        string
+	checkNameExpr         string
+	entityNameRe          *regexp.Regexp
+	checkNameRe           *regexp.Regexp
+	thresholdsArg         string
+	includeChecks         string
+	excludeChecks         string
+	requireHook           string
+	hookStatus            string
+	namespaces            string
+	allNamespaces         bool
+	namespaceLabels       string
+	namespaceBatchSize    int
+	namespaceBatchDelay   time.Duration
+	namespaceLabel        string
+	byNamespace           bool
+	warnEmptyNamespace    bool
+	critEmptyNamespace    bool
+	expectedNamespaces    string
+	critUnexpected        bool
+	eventsFile            string
+	manifest              string
+	showQueries           bool
+    apiProto              string
+	apiHost               string
+	apiPort               string
+	authPath              string
+	authJitter            time.Duration
+	apiUser               string
+	apiPass               string
+	tokenCommand          string
+	authMode              string
+	apiKey                string
+	credExpiry            string
+	credExpiresAt         time.Time
+	credWarnWindow        time.Duration
+	backendArgs           []string
+	clusters              []string
+	clusterPath           string
+	backends              []Backend
+	authCache             = map[string]Auth{}
+	eventCache            = map[string][]*types.Event{}
+	queriedNamespaces     []string
+	useGraphql            bool
+	requireVersion        string
+	queryArgs             []string
+	queryMethod           string
+	queryBody             string
+	queryParams           url.Values
+	savedSearch           string
+	maintenanceSilence    string
+	strictDecode          bool
+	pageSize              int
+	strict                bool
+	deadline              time.Duration
+	confirmDelay          time.Duration
+	shuffleNamespaces     bool
+	deadlineCtx           = context.Background()
+	caCert                string
+	tlsServerName         string
+	requireTls            bool
+    caPath                string
+	maxIdleConns          int
+	maxIdlePerHost        int
+	idleTimeout           time.Duration
+	keepAlive             time.Duration
+	warnPercent           int
+	critPercent           int
+	warnCount             int
+	critCount             int
+	warnStale             time.Duration
+	critStale             time.Duration
+	stalenessFactor       float64
+	minChecks             int
+	coverageArg           string
+	coverage              map[string]float64
+	warnCommandDrift      bool
+	detectPartialOutage   bool
+	criticalSubscriptions string
+	maxEntityIdle         time.Duration
+	entityIdleAction      string
+	excludeDeregistering  bool
+	deregisterAfter       time.Duration
+	distributionArg       string
+	distribution          map[string]float64
+	distTolerance         float64
+	recoveredWindow       time.Duration
+	warnRecovered         int
+	critRecovered         int
+	warnInvalid           int
+	critInvalid           int
+	requireOutput         bool
+	warnNoOutput          int
+	critNoOutput          int
+	invalidEvents         int
+	emptyOutputEvents     int
+	filterTrace           = map[string]int{}
+	notices               []string
+	unresolvedAfter       time.Duration
+	warnUnresolved        int
+	critUnresolved        int
+	warnMtslok            time.Duration
+	critMtslok            time.Duration
+	severityWeightsArg    string
+	severityWeights       map[string]float64
+	warnScore             int
+	critScore             int
+	minStatus             int
+	warnReliability       int
+	critReliability       int
+	metricName            string
+	metricAggregate       string
+	warnMetric            float64
+	critMetric            float64
+	thresholdLogic        string
+	rounding              string
+	precision             int
+	countRatios           bool
+	anyUnknown            bool
+	weightLabel           string
+	classifyExpr          string
+	classifyScript        *otto.Script
+	classifyVm            *otto.Otto
+	classified            map[*types.Event]bool
+	classifyErr           error
+	resolveFlapping       string
+	escalateAfter         time.Duration
+	expectedTotal         int
+	percentBaseArg        string
+	okStatusArg           string
+	okStatuses            map[uint32]bool
+	outputFormat          string
+	metricsFormat         string
+	metricsOnFailureOnly  bool
+	messageTemplate       string
+	messageTmpl           *template.Template
+	okMessage             string
+	okTmpl                *template.Template
+	jsonPretty            bool
+	jsonKeyMapArg         string
+	jsonKeyMap            map[string]string
+	jsonIncludeEvents     string
+	jsonEventsLimit       int
+	outputFile            string
+	fileFormat            string
+	verbose               bool
+	traceFilter           bool
+	statusPrefixOnly      bool
+	printPercent          bool
+	listHealthy           bool
+	histogram             bool
+	showAnnotations       string
+	maxOutputBytes        int
+	eventCheckName        string
+	listFormat            string
+	resultWebhook         string
+	pushgatewayUrl        string
+	useSyslog             bool
+	syslogFacility        string
+	syslogTag             string
+	webhookTimeout        time.Duration
+	webhookRetries        int
+	consecutive           int
+	stateFile             string
+	warnTrend             float64
+	critTrend             float64
+	trendRuns             int
+	printExitCode         bool
+)
+
+type Backend struct {
+	Url     string
+	User    string
+	Pass    string
+	Gateway string
+}
+
 type Auth struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresAt    int64  `json:"expires_at"`
 }
 
-type Counters struct {
-	Entities int
-	Checks   int
-	Ok       int
-	Warning  int
-	Critical int
-	Unknown  int
-	Total    int
+type Result struct {
+	Counters        Counters            `json:"counters"`
+	Namespaces      map[string]Counters `json:"namespaces"`
+	Percent         int                 `json:"percent"`
+	RawPercent      float64             `json:"raw_percent"`
+	Status          int                 `json:"status"`
+	Output          string              `json:"output"`
+	Failed          map[string]string   `json:"failed,omitempty"`
+	Partial         bool                `json:"partial,omitempty"`
+	MeanSinceLastOk int64               `json:"mean_since_last_ok,omitempty"`
+	Trend           float64             `json:"trend,omitempty"`
+	Healthy         []string            `json:"healthy,omitempty"`
+	Notices         []string            `json:"notices,omitempty"`
+	Events          []*types.Event      `json:"-"`
+}
+
+// Aggregate is an aggregate of a --manifest, with the flags it sets.
+type Aggregate struct {
+	Name  string                 `json:"name"`
+	Flags map[string]interface{} `json:"flags"`
+}
+
+type State struct {
+	Status   int       `json:"status"`
+	Streak   int       `json:"streak"`
+	Reported int       `json:"reported"`
+	Percents []float64 `json:"percents,omitempty"`
+}
+
+type Threshold struct {
+	Status  int
+	Tripped bool
+	Message string
+	Exit    int
+}
+
+type Counters struct {
+	Entities  int `json:"entities"`
+	Checks    int `json:"checks"`
+	Ok        int `json:"ok"`
+	Warning   int `json:"warning"`
+	Critical  int `json:"critical"`
+	Unknown   int `json:"unknown"`
+	Total     int `json:"total"`
+	Recovered int `json:"recovered"`
+	Invalid   int `json:"invalid"`
+	NoOutput  int `json:"no_output"`
+}
+
+func main() {
+	rootCmd := configureRootCommand()
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		exit(1)
+	}
+}
+
+// exit exits with the status, or prints it as EXIT:<status> and returns with
+// --print-exit-code.
+func exit(status int) {
+	if printExitCode {
+		fmt.Printf("EXIT:%d\n", status)
+		return
+	}
+
+	if status != 0 {
+		os.Exit(status)
+	}
+}
+
+func configureRootCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "sensu-aggregate-check",
+		Short:   "The Sensu Go Event Aggregates Check plugin",
+		Version: version,
+		RunE:    run,
+	}
+
+	cmd.SetVersionTemplate(versionString() + "\n")
+
+	listCmd := &cobra.Command{
+		Use:   "list-namespaces",
+		Short: "List the Namespaces visible to the Sensu Go Backend API User",
+		Args:  cobra.NoArgs,
+		RunE:  runListNamespaces,
+	}
+
+	listCmd.Flags().StringVarP(&listFormat,
+		"output",
+		"o",
+		"text",
+		"Output format, one of 'text' (one Namespace per line) or 'json'")
+
+	cmd.AddCommand(listCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "version",
+		Short: "Print the version and build information",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Println(versionString())
+		},
+	})
+
+	cmd.Flags().StringVarP(&checkLabels,
+		"check-labels",
+		"l",
+		"",
+		"Sensu Go Event Check Labels to filter by (e.g. 'aggregate=foo'), or '@path' to read them from a file")
+
+	cmd.Flags().StringVarP(&entityLabels,
+		"entity-labels",
+		"e",
+		"",
+		"Sensu Go Event Entity Labels to filter by (e.g. 'aggregate=foo,app=bar'), or '@path' to read them from a file")
+
+	cmd.Flags().BoolVarP(&latestOnly,
+		"latest-only",
+		"",
+		false,
+		"Only count the most recent Event of each Namespace, Entity and Check when duplicates are returned")
+
+	cmd.Flags().StringVarP(&ignoreAnnotation,
+		"ignore-annotation",
+		"",
+		"",
+		"Ignore Events whose Check or Entity has any of these Annotations (e.g. 'aggregate.ignore=true')")
+
+	cmd.Flags().StringVarP(&preset,
+		"preset",
+		"",
+		"",
+		"Preset of flags for a common aggregate, unless set explicitly: 'keepalives' (all keepalive Events), 'critical-only' (warnings and unknowns count as OK) or 'by-team' (counters by 'team' Entity Label)")
+
+	cmd.Flags().BoolVarP(&matchAll,
+		"match-all",
+		"",
+		false,
+		"Allow empty check labels, matching the Events of all Checks")
+
+	cmd.Flags().BoolVarP(&labelKeyFold,
+		"label-case-insensitive",
+		"",
+		false,
+		"Match the keys of the label selectors case-insensitively (e.g. 'aggregate' matches 'Aggregate')")
+
+	cmd.Flags().BoolVarP(&labelValueFold,
+		"label-value-case-insensitive",
+		"",
+		false,
+		"Match the values of the label selectors case-insensitively")
+
+	cmd.Flags().StringVarP(&eventState,
+		"state",
+		"",
+		"",
+		"Only include Events whose Check is in this state, one of 'passing', 'failing' or 'flapping' (filtered by the Sensu Go Backend)")
+
+	cmd.Flags().StringVarP(&entityPlatform,
+		"entity-platform",
+		"",
+		"",
+		"Sensu Go Event Entity System Platform to filter by (e.g. 'ubuntu')")
+
+	cmd.Flags().StringVarP(&entityArch,
+		"entity-arch",
+		"",
+		"",
+		"Sensu Go Event Entity System Arch to filter by (e.g. 'amd64')")
+
+	cmd.Flags().StringVarP(&entityNameExpr,
+		"entity-name-regex",
+		"",
+		"",
+		"Regular expression the Sensu Go Event Entity name must match (e.g. '^web-prod-')")
+
+	cmd.Flags().StringVarP(&checkNameExpr,
+		"check-name-regex",
+		"",
+		"",
+		"Regular expression the Sensu Go Event Check name must match (e.g. '^healthz')")
+
+	cmd.Flags().StringVarP(&includeChecks,
+		"include-checks",
+		"",
+		"",
+		"Comma-delimited list of Check names to include (e.g. 'check-nginx,check-disk')")
+
+	cmd.Flags().StringVarP(&excludeChecks,
+		"exclude-checks",
+		"",
+		"",
+		"Comma-delimited list of Check names to exclude, takes precedence over --include-checks")
+
+	cmd.Flags().StringVarP(&requireHook,
+		"require-hook",
+		"",
+		"",
+		"Only include Events with an execution of this Check hook")
+
+	cmd.Flags().StringVarP(&hookStatus,
+		"hook-status",
+		"",
+		"any",
+		"Only include Events with a Check hook execution in this state, one of 'any', 'ok' or 'failing'")
+
+	cmd.Flags().StringVarP(&namespaces,
+		"namespaces",
+		"n",
+		"default",
+		"Comma-delimited list of Sensu Go Namespaces to query for Events (e.g. 'us-east-1,us-west-2'), or '@path' to read them from a file")
+
+	cmd.Flags().BoolVarP(&allNamespaces,
+		"all-namespaces",
+		"",
+		false,
+		"Query the Events of all Namespaces visible to the API User instead of the --namespaces")
+
+	cmd.Flags().StringVarP(&namespaceLabels,
+		"namespace-labels",
+		"",
+		"",
+		"Sensu Go Namespace Label Selector with --all-namespaces, comma-delimited key=value pairs (e.g. 'env=prod')")
+
+	cmd.Flags().IntVarP(&namespaceBatchSize,
+		"namespace-batch-size",
+		"",
+		0,
+		"Query the Namespaces in batches of this size, sleeping --namespace-batch-delay between batches (0 disables batching)")
+
+	cmd.Flags().DurationVarP(&namespaceBatchDelay,
+		"namespace-batch-delay",
+		"",
+		time.Second,
+		"Delay between batches of --namespace-batch-size Namespaces")
+
+	cmd.Flags().StringVarP(&namespaceLabel,
+		"namespace-label",
+		"",
+		"",
+		"Entity Label to group Events by instead of their Sensu Go Namespace")
+
+	cmd.Flags().BoolVarP(&byNamespace,
+		"by-namespace",
+		"",
+		false,
+		"Print the counters of each Namespace (or --namespace-label value)")
+
+	cmd.Flags().BoolVarP(&warnEmptyNamespace,
+		"warn-if-any-namespace-empty",
+		"",
+		false,
+		"Warning threshold - any of the --namespaces has no matching Events")
+
+	cmd.Flags().BoolVarP(&critEmptyNamespace,
+		"crit-if-any-namespace-empty",
+		"",
+		false,
+		"Critical threshold - any of the --namespaces has no matching Events")
+
+	cmd.Flags().StringVarP(&expectedNamespaces,
+		"expected-namespaces",
+		"",
+		"",
+		"Comma-delimited list of Namespaces, warn when Events match in other Namespaces (e.g. with --all-namespaces)")
+
+	cmd.Flags().BoolVarP(&critUnexpected,
+		"crit-if-unexpected-namespace",
+		"",
+		false,
+		"Return critical instead of warning when Events match outside the --expected-namespaces")
+
+	cmd.Flags().StringVarP(&manifest,
+		"manifest",
+		"",
+		"",
+		"JSON file with several named aggregates, each setting flags, to evaluate in one invocation (exits with the worst status)")
+
+	cmd.Flags().BoolVarP(&showQueries,
+		"show-queries",
+		"",
+		false,
+		"Print the Sensu Go Backend API URLs that would be requested, without requesting them")
+
+	cmd.Flags().StringVarP(&eventsFile,
+		"events-file",
+		"",
+		"",
+		"Read a JSON array of Events from a file ('-' for stdin) instead of querying the Sensu Go Backend API")
+
+    cmd.PersistentFlags().StringVarP(&apiProto,
+        "api-proto",
+        "",
+        "http",
+        "Sensu Go Backend API Protocol (e.g. 'https')")
+
+	cmd.PersistentFlags().StringVarP(&apiHost,
+		"api-host",
+		"H",
+		"127.0.0.1",
+		"Sensu Go Backend API Host (e.g. 'sensu-backend.example.com')")
+
+	cmd.PersistentFlags().StringVarP(&apiPort,
+		"api-port",
+		"p",
+		"8080",
+		"Sensu Go Backend API Port (e.g. 4242)")
+
+	cmd.PersistentFlags().StringVarP(&tokenCommand,
+		"token-command",
+		"",
+		"",
+		"Shell command printing the access token to use instead of authenticating with the API User (e.g. 'vault read -field=token secret/sensu')")
+
+	cmd.PersistentFlags().StringVarP(&authMode,
+		"auth-mode",
+		"",
+		"bearer",
+		"Authentication scheme: bearer (access token of the API User or --token-command), key (Sensu Go API key) or basic (API User and password on each request)")
+
+	cmd.PersistentFlags().StringVarP(&apiKey,
+		"api-key",
+		"",
+		"",
+		"Sensu Go API key for --auth-mode key (default $SENSU_API_KEY)")
+
+	cmd.PersistentFlags().StringVarP(&credExpiry,
+		"cred-expiry",
+		"",
+		"",
+		"Expiry of the API User credentials (e.g. '2025-06-30' or an RFC 3339 time), instead of the expiry of the access token")
+
+	cmd.PersistentFlags().DurationVarP(&credWarnWindow,
+		"cred-warn-window",
+		"",
+		0,
+		"Add a notice to the output when the credentials expire within this duration (e.g. '168h')")
+
+	cmd.PersistentFlags().StringVarP(&authPath,
+		"auth-path",
+		"",
+		"/auth",
+		"Sensu Go Backend API authentication path (e.g. '/oauth/token')")
+
+	cmd.PersistentFlags().DurationVarP(&authJitter,
+		"auth-jitter",
+		"",
+		0,
+		"Sleep a random duration up to this value before authenticating, to spread load on the Sensu Go Backend API")
+
+	cmd.PersistentFlags().StringVarP(&apiUser,
+		"api-user",
+		"u",
+		"admin",
+		"Sensu Go Backend API User")
+
+	cmd.PersistentFlags().StringVarP(&apiPass,
+		"api-pass",
+		"P",
+		"P@ssw0rd!",
+		"Sensu Go Backend API User")
+
+	cmd.PersistentFlags().StringArrayVarP(&backendArgs,
+		"backend",
+		"",
+		[]string{},
+		"Sensu Go Backend API to aggregate, as 'url[,user,pass]' (e.g. 'https://sensu-eu.example.com:8080,foo,bar'), repeat for multiple backends (overrides --api-proto, --api-host and --api-port)")
+
+	cmd.Flags().StringArrayVarP(&clusters,
+		"cluster",
+		"",
+		[]string{},
+		"Federated cluster to aggregate through the Sensu Go Backend as federation gateway, repeat for multiple clusters")
+
+	cmd.Flags().StringVarP(&clusterPath,
+		"cluster-path",
+		"",
+		"/api/enterprise/federation/v1/clusters/{cluster}",
+		"Path prefixed to the API requests to a --cluster through the federation gateway, '{cluster}' is replaced by the cluster name")
+
+	cmd.Flags().BoolVarP(&useGraphql,
+		"use-graphql",
+		"",
+		false,
+		"Query the Events of all Namespaces in a single request to the Sensu Go GraphQL API, falling back to the REST API")
+
+	cmd.Flags().StringVarP(&requireVersion,
+		"require-version",
+		"",
+		"",
+		"Fail unless the Sensu Go Backend version satisfies these constraints (e.g. '>=5.10,<6')")
+
+	cmd.Flags().DurationVarP(&deadline,
+		"deadline",
+		"",
+		0,
+		"Stop querying Namespaces after this duration and evaluate the Events returned so far as a partial result (e.g. '30s')")
+
+	cmd.Flags().BoolVarP(&shuffleNamespaces,
+		"shuffle-namespaces",
+		"",
+		false,
+		"Query the Namespaces in a random order, so the --deadline does not always skip the same Namespaces")
+
+	cmd.Flags().DurationVarP(&confirmDelay,
+		"confirm-delay",
+		"",
+		0,
+		"Query the Events again after this duration when the status is critical, and return warning instead when it is no longer critical (e.g. '10s')")
+
+	cmd.Flags().BoolVarP(&strict,
+		"strict",
+		"",
+		false,
+		"Fail when querying the Events of any Namespace fails, instead of evaluating the Namespaces that succeeded")
+
+	cmd.Flags().IntVarP(&pageSize,
+		"page-size",
+		"",
+		0,
+		"Number of Events to request per page, following the continue token of the Sensu Go Backend (0 requests all Events at once)")
+
+	cmd.Flags().BoolVarP(&strictDecode,
+		"strict-decode",
+		"",
+		false,
+		"Fail on Event fields unknown to this plugin instead of ignoring them")
+
+	cmd.Flags().StringVarP(&savedSearch,
+		"saved-search",
+		"",
+		"",
+		"Apply the selectors of this Sensu Go (enterprise) saved search to the Events API request")
+
+	cmd.Flags().StringVarP(&maintenanceSilence,
+		"maintenance-silence",
+		"",
+		"",
+		"Return OK without evaluating the Events while this silenced entry is active (e.g. 'maintenance:*')")
+
+	cmd.Flags().StringArrayVarP(&queryArgs,
+		"query",
+		"",
+		[]string{},
+		"Query parameter to add to the Events API request as 'key=value' (e.g. 'labelSelector=region == eu'), repeat for multiple parameters")
+
+	cmd.Flags().StringVarP(&queryMethod,
+		"query-method",
+		"",
+		"GET",
+		"HTTP method of the Events API requests, GET or POST (e.g. for a gateway in front of the Sensu Go Backend)")
+
+	cmd.Flags().StringVarP(&queryBody,
+		"query-body",
+		"",
+		"",
+		"JSON body to send with the Events API requests")
+
+    cmd.PersistentFlags().StringVarP(&caPath,
+        "ca-path",
+        "",
+        "",
+        "Path to CA certificate")
+
+	cmd.PersistentFlags().StringVarP(&caCert,
+		"ca-cert",
+		"",
+		"",
+		"PEM encoded CA certificate, takes precedence over --ca-path (default $SENSU_CA_CERT)")
+
+	cmd.PersistentFlags().StringVarP(&tlsServerName,
+		"tls-server-name",
+		"",
+		"",
+		"Server name used to verify the Sensu Go Backend API certificate, when it differs from the API host")
+
+	cmd.PersistentFlags().BoolVarP(&requireTls,
+		"require-tls",
+		"",
+		false,
+		"Fail unless the Sensu Go Backend API is accessed over https")
+
+	cmd.PersistentFlags().IntVarP(&maxIdleConns,
+		"max-idle-conns",
+		"",
+		100,
+		"Maximum number of idle (keep-alive) connections to the Sensu Go Backend API")
+
+	cmd.PersistentFlags().IntVarP(&maxIdlePerHost,
+		"max-idle-conns-per-host",
+		"",
+		http.DefaultMaxIdleConnsPerHost,
+		"Maximum number of idle (keep-alive) connections per Sensu Go Backend API host")
+
+	cmd.PersistentFlags().DurationVarP(&idleTimeout,
+		"idle-conn-timeout",
+		"",
+		90*time.Second,
+		"How long an idle (keep-alive) connection remains open before closing itself")
+
+	cmd.PersistentFlags().DurationVarP(&keepAlive,
+		"keep-alive",
+		"",
+		30*time.Second,
+		"TCP keep-alive period for connections to the Sensu Go Backend API (negative disables keep-alives)")
+
+	cmd.Flags().StringVarP(&thresholdsArg,
+		"thresholds",
+		"",
+		"",
+		"Comma-delimited thresholds as 'severity:kind=value', alternative to the individual --warn-* and --crit-* flags (e.g. 'warn:count=5,crit:count=2,crit:percent=90')")
+
+	cmd.Flags().IntVarP(&warnPercent,
+		"warn-percent",
+		"w",
+		0,
+		"Warning threshold - % of Events in warning state")
+
+	cmd.Flags().IntVarP(&critPercent,
+		"crit-percent",
+		"c",
+		0,
+		"Critical threshold - % of Events in critical state")
+
+	cmd.Flags().IntVarP(&warnCount,
+		"warn-count",
+		"W",
+		0,
+		"Warning threshold - count of Events in warning state")
+
+	cmd.Flags().IntVarP(&critCount,
+		"crit-count",
+		"C",
+		0,
+		"Critical threshold - count of Events in critical state")
+
+	cmd.Flags().DurationVarP(&warnStale,
+		"warn-stale",
+		"",
+		0,
+		"Warning threshold - age of the oldest Event (e.g. '10m')")
+
+	cmd.Flags().DurationVarP(&critStale,
+		"crit-stale",
+		"",
+		0,
+		"Critical threshold - age of the oldest Event (e.g. '30m')")
+
+	cmd.Flags().Float64VarP(&stalenessFactor,
+		"interval-staleness-factor",
+		"",
+		0,
+		"Warning threshold - age of an Event as a multiple of its Check interval (e.g. 3 warns about a Check with a 60s interval when its Event is older than 3m)")
+
+	cmd.Flags().DurationVarP(&recoveredWindow,
+		"recovered-window",
+		"",
+		0,
+		"Count Events in OK state that were not OK within this window as recovered (e.g. '15m')")
+
+	cmd.Flags().IntVarP(&warnRecovered,
+		"warn-recovered",
+		"",
+		0,
+		"Warning threshold - count of recently recovered Events (requires --recovered-window)")
+
+	cmd.Flags().IntVarP(&critRecovered,
+		"crit-recovered",
+		"",
+		0,
+		"Critical threshold - count of recently recovered Events (requires --recovered-window)")
+
+	cmd.Flags().IntVarP(&warnInvalid,
+		"warn-invalid",
+		"",
+		0,
+		"Warning threshold - count of Events returned without Check or Entity")
+
+	cmd.Flags().IntVarP(&critInvalid,
+		"crit-invalid",
+		"",
+		0,
+		"Critical threshold - count of Events returned without Check or Entity")
+
+	cmd.Flags().BoolVarP(&requireOutput,
+		"require-output",
+		"",
+		false,
+		"Only count Events with a non-empty Check output, and count the others as NoOutput")
+
+	cmd.Flags().IntVarP(&warnNoOutput,
+		"warn-no-output",
+		"",
+		0,
+		"Warning threshold - count of Events without Check output (requires --require-output)")
+
+	cmd.Flags().IntVarP(&critNoOutput,
+		"crit-no-output",
+		"",
+		0,
+		"Critical threshold - count of Events without Check output (requires --require-output)")
+
+	cmd.Flags().DurationVarP(&unresolvedAfter,
+		"unresolved-after",
+		"",
+		0,
+		"Count critical Events whose Check was last OK longer than this duration ago as unresolved (e.g. '30m')")
+
+	cmd.Flags().IntVarP(&warnUnresolved,
+		"warn-unresolved",
+		"",
+		0,
+		"Warning threshold - count of unresolved critical Events (requires --unresolved-after)")
+
+	cmd.Flags().IntVarP(&critUnresolved,
+		"crit-unresolved",
+		"",
+		0,
+		"Critical threshold - count of unresolved critical Events (requires --unresolved-after)")
+
+	cmd.Flags().DurationVarP(&warnMtslok,
+		"warn-mtslok",
+		"",
+		0,
+		"Warning threshold - mean time since the critical Events were last OK")
+
+	cmd.Flags().DurationVarP(&critMtslok,
+		"crit-mtslok",
+		"",
+		0,
+		"Critical threshold - mean time since the critical Events were last OK")
+
+	cmd.Flags().StringVarP(&severityWeightsArg,
+		"severity-weights",
+		"",
+		"warning=0.5,critical=1,unknown=1",
+		"Weights of the states in the severity score, 100 - (warning*w + critical*w + unknown*w) / total * 100")
+
+	cmd.Flags().IntVarP(&warnScore,
+		"warn-score",
+		"",
+		0,
+		"Warning threshold - severity score (see --severity-weights)")
+
+	cmd.Flags().IntVarP(&critScore,
+		"crit-score",
+		"",
+		0,
+		"Critical threshold - severity score (see --severity-weights)")
+
+	cmd.Flags().IntVarP(&warnReliability,
+		"warn-reliability",
+		"",
+		0,
+		"Warning threshold - average % of OK executions in the Check History of the Events")
+
+	cmd.Flags().IntVarP(&critReliability,
+		"crit-reliability",
+		"",
+		0,
+		"Critical threshold - average % of OK executions in the Check History of the Events")
+
+	cmd.Flags().StringVarP(&metricName,
+		"metric-name",
+		"",
+		"",
+		"Name of the Event metric point to aggregate across the Events (e.g. 'cpu.usage')")
+
+	cmd.Flags().StringVarP(&metricAggregate,
+		"metric-aggregate",
+		"",
+		"avg",
+		"Aggregate of the --metric-name values, one of 'avg', 'max' or 'sum'")
+
+	cmd.Flags().Float64VarP(&warnMetric,
+		"warn-metric",
+		"",
+		0,
+		"Warning threshold - aggregate of the --metric-name values")
+
+	cmd.Flags().Float64VarP(&critMetric,
+		"crit-metric",
+		"",
+		0,
+		"Critical threshold - aggregate of the --metric-name values")
+
+	cmd.Flags().StringVarP(&distributionArg,
+		"expected-distribution",
+		"",
+		"",
+		"Warning threshold - expected % of Events per state (e.g. 'ok=90,warning=5,critical=5')")
+
+	cmd.Flags().Float64VarP(&distTolerance,
+		"distribution-tolerance",
+		"",
+		5,
+		"Maximum deviation in % of each state from the --expected-distribution")
+
+	cmd.Flags().BoolVarP(&warnCommandDrift,
+		"warn-command-drift",
+		"",
+		false,
+		"Warning threshold - a Check runs different commands on different Entities")
+
+	cmd.Flags().DurationVarP(&maxEntityIdle,
+		"max-entity-idle",
+		"",
+		0,
+		"Maximum time since an Entity was last seen (e.g. '10m'), see --entity-idle-action")
+
+	cmd.Flags().StringVarP(&entityIdleAction,
+		"entity-idle-action",
+		"",
+		"flag",
+		"Action for Events of Entities idle for longer than --max-entity-idle, 'flag' (Warning threshold) or 'drop' (exclude the Events)")
+
+	cmd.Flags().BoolVarP(&excludeDeregistering,
+		"exclude-deregistering",
+		"",
+		false,
+		"Exclude the Events of agent Entities with deregistration enabled that were last seen longer than --deregister-after ago")
+
+	cmd.Flags().DurationVarP(&deregisterAfter,
+		"deregister-after",
+		"",
+		2*time.Minute,
+		"Keepalive timeout after which Entities with deregistration enabled are deregistered, see --exclude-deregistering")
+
+	cmd.Flags().StringVarP(&criticalSubscriptions,
+		"critical-subscriptions",
+		"",
+		"",
+		"Critical threshold - comma-delimited list of Entity subscriptions whose Entities must have no Events that are not OK (e.g. 'critical-tier')")
+
+	cmd.Flags().BoolVarP(&detectPartialOutage,
+		"detect-partial-outage",
+		"",
+		false,
+		"Critical threshold - a Check is OK on some Entities but critical on others")
+
+	cmd.Flags().IntVarP(&minChecks,
+		"min-checks-per-entity",
+		"",
+		0,
+		"Warning threshold - minimum count of distinct checks reported by each Entity")
+
+	cmd.Flags().StringVarP(&coverageArg,
+		"require-check-coverage",
+		"",
+		"",
+		"Warning threshold - minimum % of the Entities with an Event for a Check, as 'check=percent' (e.g. 'security-agent=95')")
+
+	cmd.Flags().StringVarP(&thresholdLogic,
+		"threshold-logic",
+		"",
+		"any",
+		"Whether 'any' threshold of a severity or 'all' thresholds set for it must be exceeded")
+
+	cmd.Flags().StringVarP(&rounding,
+		"round",
+		"",
+		"floor",
+		"Rounding of the % of Events in OK state, one of 'floor', 'ceil' or 'nearest'")
+
+	cmd.Flags().IntVarP(&precision,
+		"precision",
+		"",
+		0,
+		"Number of decimals of the displayed % of Events in OK state")
+
+	cmd.Flags().BoolVarP(&countRatios,
+		"count-ratios",
+		"",
+		false,
+		"Display the counters as 'N/total'")
+
+	cmd.Flags().BoolVarP(&anyUnknown,
+		"any-unknown-is-unknown",
+		"",
+		false,
+		"Return an Unknown state as soon as any Event is in an Unknown state")
+
+	cmd.Flags().StringVarP(&okStatusArg,
+		"ok-statuses",
+		"",
+		"",
+		"Comma-delimited list of additional Check statuses to count as OK (e.g. '3,4')")
+
+	cmd.Flags().IntVarP(&expectedTotal,
+		"expected-total",
+		"",
+		0,
+		"Expected number of Events, the percentage OK is computed over this number when fewer Events are returned")
+
+	cmd.Flags().StringVarP(&percentBaseArg,
+		"percent-base",
+		"",
+		"",
+		"Number of Events the percentage OK is computed over: total, known (without Unknown Events) or expected (--expected-total when more) (default expected with --expected-total, total otherwise)")
+
+	cmd.Flags().StringVarP(&resolveFlapping,
+		"resolve-flapping",
+		"",
+		"",
+		"Count flapping Events by their 'last-stable' status in the Check History, their 'worst' status or as 'unknown'")
+
+	cmd.Flags().DurationVarP(&escalateAfter,
+		"warn-escalate-after",
+		"",
+		0,
+		"Count warning Events whose Check was last OK longer than this duration ago as critical (e.g. '24h')")
+
+	cmd.Flags().StringVarP(&classifyExpr,
+		"classify-expr",
+		"",
+		"",
+		"JavaScript expression deciding whether an Event counts as OK, over status, labels, entity, check, namespace and age (e.g. \"status == 0 || (status == 1 && labels.tier == 'dev')\")")
+
+	cmd.Flags().StringVarP(&weightLabel,
+		"weight-label",
+		"",
+		"",
+		"Check or Entity Label holding the numeric weight of each Event in the counters (default weight 1)")
+
+	cmd.Flags().StringVarP(&outputFormat,
+		"output",
+		"o",
+		"text",
+		"Output format, one of 'text', 'json', 'csv' or 'sensu-event' (a Sensu Go check result for chaining)")
+
+	cmd.Flags().StringVarP(&messageTemplate,
+		"message-template",
+		"",
+		"{{.Output}}",
+		"Go template of the status line, over .Counters, .Namespaces, .Percent, .RawPercent, .Status, .StatusName, .Output, .CheckLabels and .EntityLabels")
+
+	cmd.Flags().StringVarP(&okMessage,
+		"ok-message",
+		"",
+		"Everything is OK",
+		"Go template of the status line when no threshold is exceeded, with the fields of --message-template")
+
+	cmd.Flags().BoolVarP(&printExitCode,
+		"print-exit-code",
+		"",
+		false,
+		"Print the exit status as EXIT:<status> and exit with 0, for wrappers")
+
+	cmd.Flags().BoolVarP(&jsonPretty,
+		"json-pretty",
+		"",
+		false,
+		"Indent the 'json' and 'sensu-event' output instead of printing it on a single line")
+
+	cmd.Flags().StringVarP(&jsonKeyMapArg,
+		"json-key-map",
+		"",
+		"",
+		"Rename keys of the 'json' output and --result-webhook, as 'key=name' (e.g. 'ok=num_ok,total=num_total')")
+
+	cmd.Flags().StringVarP(&jsonIncludeEvents,
+		"json-include-events",
+		"",
+		"none",
+		"Include the matched Events in the 'json' output under 'events': none, summary (namespace, entity, check and status) or full")
+
+	cmd.Flags().IntVarP(&jsonEventsLimit,
+		"json-events-limit",
+		"",
+		100,
+		"Maximum number of Events to include with --json-include-events, 0 for no limit")
+
+	cmd.Flags().StringVarP(&metricsFormat,
+		"metrics-format",
+		"",
+		"",
+		"Append the counters as metrics to the 'text' output, one of 'graphite', 'influx' or 'prometheus'")
+
+	cmd.Flags().BoolVarP(&metricsOnFailureOnly,
+		"metrics-on-failure-only",
+		"",
+		false,
+		"Only output metrics (--metrics-format and 'sensu-event' metric points) when the status is not OK")
+
+	cmd.Flags().StringVarP(&outputFile,
+		"output-file",
+		"",
+		"",
+		"Also write the result to this file, in the --file-format")
+
+	cmd.Flags().StringVarP(&fileFormat,
+		"file-format",
+		"",
+		"json",
+		"Format of the --output-file, one of 'text', 'json', 'csv' or 'sensu-event'")
+
+	cmd.Flags().BoolVarP(&verbose,
+		"verbose",
+		"v",
+		false,
+		"List the Events that are not in an OK state")
+
+	cmd.Flags().BoolVarP(&traceFilter,
+		"trace-filter",
+		"",
+		false,
+		"Print the number of Events filtered out by each flag, e.g. 'Filtered: 3 rejected by check-label aggregate=foo'")
+
+	cmd.Flags().BoolVarP(&printPercent,
+		"print-percent",
+		"",
+		false,
+		"Only print the percentage OK, with the --percent-base, --round and --precision, and exit with 0")
+
+	cmd.Flags().BoolVarP(&statusPrefixOnly,
+		"status-prefix-only",
+		"",
+		false,
+		"Start the text output with the status line, of which the first word is always OK, WARNING, CRITICAL or UNKNOWN")
+
+	cmd.Flags().BoolVarP(&listHealthy,
+		"list-healthy",
+		"",
+		false,
+		"List the Entities of which all Events are in an OK state, in the text output or as 'healthy' in the JSON output")
+
+	cmd.Flags().BoolVarP(&histogram,
+		"histogram",
+		"",
+		false,
+		"Print a histogram of the proportion of Events in each state")
+
+	cmd.Flags().StringVarP(&showAnnotations,
+		"show-annotations",
+		"",
+		"",
+		"Comma-delimited list of Check, Entity or Event Annotations to include in the verbose output (e.g. 'runbook')")
+
+	cmd.Flags().IntVarP(&maxOutputBytes,
+		"max-output-bytes",
+		"",
+		0,
+		"Truncate the verbose output to keep the output within this number of bytes")
+
+	cmd.Flags().StringVarP(&eventCheckName,
+		"event-check-name",
+		"",
+		"sensu-aggregate-check",
+		"Check name used in the 'sensu-event' output")
+
+	cmd.Flags().StringVarP(&resultWebhook,
+		"result-webhook",
+		"",
+		"",
+		"URL to POST the JSON result to after evaluation")
+
+	cmd.Flags().StringVarP(&pushgatewayUrl,
+		"pushgateway-url",
+		"",
+		"",
+		"URL of a Prometheus Pushgateway to push the metrics to after evaluation, with the --webhook-timeout and --webhook-retries")
+
+	cmd.Flags().BoolVarP(&useSyslog,
+		"syslog",
+		"",
+		false,
+		"Also write the status line to the local syslog, with a severity by the status (ignored on platforms without syslog)")
+
+	cmd.Flags().StringVarP(&syslogFacility,
+		"syslog-facility",
+		"",
+		"user",
+		"Syslog facility for --syslog (e.g. 'daemon' or 'local0')")
+
+	cmd.Flags().StringVarP(&syslogTag,
+		"syslog-tag",
+		"",
+		"sensu-aggregate-check",
+		"Syslog tag for --syslog")
+
+	cmd.Flags().DurationVarP(&webhookTimeout,
+		"webhook-timeout",
+		"",
+		10*time.Second,
+		"Timeout of each POST to the result webhook")
+
+	cmd.Flags().IntVarP(&webhookRetries,
+		"webhook-retries",
+		"",
+		2,
+		"Number of times to retry a failed POST to the result webhook")
+
+	cmd.Flags().IntVarP(&minStatus,
+		"min-status",
+		"",
+		0,
+		"Lowest status to return, lower statuses are raised to it (e.g. 1 to never return OK)")
+
+	cmd.Flags().IntVarP(&consecutive,
+		"consecutive",
+		"",
+		1,
+		"Number of consecutive runs a status must be returned before escalating to it")
+
+	cmd.Flags().StringVarP(&stateFile,
+		"state-file",
+		"",
+		filepath.Join(os.TempDir(), "sensu-aggregate-check.json"),
+		"Path to the file persisting state between runs")
+
+	cmd.Flags().Float64VarP(&warnTrend,
+		"warn-trend",
+		"",
+		0,
+		"Warning threshold - decline of the % of Events in OK state per run over the last --trend-runs runs")
+
+	cmd.Flags().Float64VarP(&critTrend,
+		"crit-trend",
+		"",
+		0,
+		"Critical threshold - decline of the % of Events in OK state per run over the last --trend-runs runs")
+
+	cmd.Flags().IntVarP(&trendRuns,
+		"trend-runs",
+		"",
+		5,
+		"Number of runs to compute the trend of the % of Events in OK state over, kept in the --state-file")
+
+	return cmd
+}
+
+func versionString() string {
+	return fmt.Sprintf("sensu-aggregate-check version %s, commit %s, built %s", version, commit, date)
+}
+
+func runListNamespaces(cmd *cobra.Command, args []string) error {
+	if listFormat != "text" && listFormat != "json" {
+		return fmt.Errorf("invalid output format %q", listFormat)
+	}
+
+	err := initClient()
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+
+	for _, backend := range backends {
+		auth, err := authenticate(backend)
+		if err != nil {
+			return err
+		}
+
+		list, err := getNamespaces(backend, auth)
+		if err != nil {
+			return err
+		}
+
+		for _, namespace := range list {
+			names = append(names, namespace.Name)
+		}
+	}
+
+	if listFormat == "json" {
+		data, err := json.Marshal(names)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	for _, name := range names {
+		fmt.Println(name)
+	}
+
+	return nil
+}
+
+func run(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		_ = cmd.Help()
+		return fmt.Errorf("invalid argument(s) received")
+	}
+
+	if manifest != "" {
+		return runManifest(cmd)
+	}
+
+	status, err := runAggregate(cmd)
+	if err != nil {
+		return err
+	}
+
+	exit(status)
+
+	return nil
+}
+
+// runManifest evaluates every aggregate of the --manifest, each with its flags
+// set on top of the command line flags, and exits with the worst status.
+func runManifest(cmd *cobra.Command) error {
+	data, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		return err
+	}
+
+	aggregates := []Aggregate{}
+
+	err = json.Unmarshal(data, &aggregates)
+	if err != nil {
+		return fmt.Errorf("invalid manifest: %v", err)
+	}
+
+	worst := 0
+
+	for _, aggregate := range aggregates {
+		fmt.Printf("Aggregate %s:\n", aggregate.Name)
+
+		status, err := runManifestAggregate(cmd, aggregate)
+		if err != nil {
+			return fmt.Errorf("aggregate %s: %v", aggregate.Name, err)
+		}
+
+		if status > worst {
+			worst = status
+		}
+	}
+
+	exit(worst)
+
+	return nil
+}
+
+// runManifestAggregate evaluates an aggregate of the --manifest, restoring
+// all flags afterwards, including those set by its --preset or --thresholds.
+func runManifestAggregate(cmd *cobra.Command, aggregate Aggregate) (int, error) {
+	defer restoreFlags(cmd.Flags(), snapshotFlags(cmd.Flags()))
+
+	for name, value := range aggregate.Flags {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || name == "manifest" {
+			return 0, fmt.Errorf("unknown flag %q", name)
+		}
+
+		if cmd.PersistentFlags().Lookup(name) != nil || flag.Value.Type() == "stringArray" {
+			return 0, fmt.Errorf("flag %q cannot be set per aggregate", name)
+		}
+
+		if err := cmd.Flags().Set(name, fmt.Sprint(value)); err != nil {
+			return 0, err
+		}
+	}
+
+	return runAggregate(cmd)
+}
+
+// flagState is the value of a flag and whether it was set.
+type flagState struct {
+	value   string
+	changed bool
+}
+
+// snapshotFlags returns the state of the flags, except the stringArray flags
+// that cannot be set per aggregate, to restore them with restoreFlags.
+func snapshotFlags(flags *pflag.FlagSet) map[string]flagState {
+	states := map[string]flagState{}
+
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Value.Type() != "stringArray" {
+			states[flag.Name] = flagState{flag.Value.String(), flag.Changed}
+		}
+	})
+
+	return states
+}
+
+// restoreFlags restores the state of the flags returned by snapshotFlags.
+func restoreFlags(flags *pflag.FlagSet, states map[string]flagState) {
+	for name, state := range states {
+		flag := flags.Lookup(name)
+		_ = flag.Value.Set(state.value)
+		flag.Changed = state.changed
+	}
+}
+
+// runAggregate validates the flags and evaluates the aggregate they define,
+// returning its status.
+func runAggregate(cmd *cobra.Command) (int, error) {
+	entityNameRe = nil
+	checkNameRe = nil
+	classifyScript = nil
+	classified = map[*types.Event]bool{}
+	classifyErr = nil
+	invalidEvents = 0
+	emptyOutputEvents = 0
+	filterTrace = map[string]int{}
+
+	if preset != "" {
+		values, ok := presets[preset]
+		if !ok {
+			return 0, fmt.Errorf("invalid preset %q", preset)
+		}
+
+		for name, value := range values {
+			if cmd.Flags().Changed(name) {
+				continue
+			}
+
+			if err := cmd.Flags().Set(name, value); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if thresholdsArg != "" {
+		values, err := parseThresholds(thresholdsArg)
+		if err != nil {
+			return 0, err
+		}
+
+		for name, value := range values {
+			if cmd.Flags().Changed(name) {
+				continue
+			}
+
+			if err := cmd.Flags().Set(name, value); err != nil {
+				return 0, fmt.Errorf("invalid threshold %s: %v", name, err)
+			}
+		}
+	}
+
+	if !cmd.Flags().Changed("check-labels") {
+		return 0, fmt.Errorf("required flag(s) \"check-labels\" not set")
+	}
+
+	for _, arg := range []*string{&checkLabels, &entityLabels, &namespaces} {
+		value, err := readArgFile(*arg)
+		if err != nil {
+			return 0, err
+		}
+		*arg = value
+	}
+
+	namespaces = normalizeNamespaces(namespaces)
+	if namespaces == "" && !allNamespaces {
+		return 0, fmt.Errorf("no namespaces to query")
+	}
+
+	if (warnRecovered != 0 || critRecovered != 0) && recoveredWindow == 0 {
+		return 0, fmt.Errorf("--warn-recovered and --crit-recovered require --recovered-window")
+	}
+
+	if (warnMetric != 0 || critMetric != 0) && metricName == "" {
+		return 0, fmt.Errorf("--warn-metric and --crit-metric require --metric-name")
+	}
+
+	if entityIdleAction != "flag" && entityIdleAction != "drop" {
+		return 0, fmt.Errorf("invalid entity idle action %q", entityIdleAction)
+	}
+
+	if resolveFlapping != "" && resolveFlapping != "last-stable" && resolveFlapping != "worst" && resolveFlapping != "unknown" {
+		return 0, fmt.Errorf("invalid flapping resolution %q", resolveFlapping)
+	}
+
+	if metricAggregate != "avg" && metricAggregate != "max" && metricAggregate != "sum" {
+		return 0, fmt.Errorf("invalid metric aggregate %q", metricAggregate)
+	}
+
+	if (warnUnresolved != 0 || critUnresolved != 0) && unresolvedAfter == 0 {
+		return 0, fmt.Errorf("--warn-unresolved and --crit-unresolved require --unresolved-after")
+	}
+
+	var err error
+
+	okStatuses, err = parseStatuses(okStatusArg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ok statuses: %v", err)
+	}
+
+	queryParams = url.Values{}
+
+	for _, arg := range queryArgs {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return 0, fmt.Errorf("invalid query parameter %q, expected 'key=value'", arg)
+		}
+		queryParams.Add(parts[0], parts[1])
+	}
+
+	if queryMethod != "GET" && queryMethod != "POST" {
+		return 0, fmt.Errorf("invalid query method %q", queryMethod)
+	}
+
+	if queryBody != "" && !json.Valid([]byte(queryBody)) {
+		return 0, fmt.Errorf("invalid query body, expected JSON")
+	}
+
+	if useGraphql && (len(queryArgs) > 0 || savedSearch != "" || queryMethod != "GET" || queryBody != "" || pageSize > 0) {
+		return 0, fmt.Errorf("--use-graphql cannot be combined with --query, --saved-search, --query-method, --query-body or --page-size")
+	}
+
+	if useGraphql && metricName != "" {
+		return 0, fmt.Errorf("--use-graphql cannot be combined with --metric-name, the GraphQL API does not return the metrics of Events")
+	}
+
+	distribution, err = parseDistribution(distributionArg)
+	if err != nil {
+		return 0, err
+	}
+
+	severityWeights, err = parseWeights(severityWeightsArg)
+	if err != nil {
+		return 0, err
+	}
+
+	credExpiresAt, err = parseExpiry(credExpiry)
+	if err != nil {
+		return 0, fmt.Errorf("invalid credential expiry %q, expected a date (e.g. '2025-06-30') or an RFC 3339 time", credExpiry)
+	}
+
+	if useSyslog {
+		if err := checkSyslogFacility(syslogFacility); err != nil {
+			return 0, err
+		}
+	}
+
+	coverage, err = parseCoverage(coverageArg)
+	if err != nil {
+		return 0, err
+	}
+
+	checkSelector, err = parseLabelArg(checkLabels)
+	if err != nil {
+		return 0, fmt.Errorf("invalid check labels: %v", err)
+	}
+
+	entitySelector, err = parseLabelArg(entityLabels)
+	if err != nil {
+		return 0, fmt.Errorf("invalid entity labels: %v", err)
+	}
+
+	jsonKeyMap, err = parseLabelArg(jsonKeyMapArg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid JSON key map: %v", err)
+	}
+
+	ignoreSelector, err = parseLabelArg(ignoreAnnotation)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ignore annotation: %v", err)
+	}
+
+	namespaceSelector, err = parseLabelArg(namespaceLabels)
+	if err != nil {
+		return 0, fmt.Errorf("invalid namespace labels: %v", err)
+	}
+
+	if len(namespaceSelector) > 0 && !allNamespaces {
+		return 0, fmt.Errorf("--namespace-labels requires --all-namespaces")
+	}
+
+	if len(checkSelector) == 0 && !matchAll {
+		return 0, fmt.Errorf("empty check labels would match all Events, use --match-all if this is intended")
+	}
+
+	if hookStatus != "any" && hookStatus != "ok" && hookStatus != "failing" {
+		return 0, fmt.Errorf("invalid hook status %q", hookStatus)
+	}
+
+	if thresholdLogic != "any" && thresholdLogic != "all" {
+		return 0, fmt.Errorf("invalid threshold logic %q", thresholdLogic)
+	}
+
+	if rounding != "floor" && rounding != "ceil" && rounding != "nearest" {
+		return 0, fmt.Errorf("invalid rounding %q", rounding)
+	}
+
+	if namespaceBatchSize < 0 {
+		return 0, fmt.Errorf("invalid namespace batch size %d", namespaceBatchSize)
+	}
+
+	if expectedTotal < 0 {
+		return 0, fmt.Errorf("invalid expected total %d", expectedTotal)
+	}
+
+	if stalenessFactor < 0 {
+		return 0, fmt.Errorf("invalid interval staleness factor %g", stalenessFactor)
+	}
+
+	if percentBaseArg != "" && percentBaseArg != "total" && percentBaseArg != "known" && percentBaseArg != "expected" {
+		return 0, fmt.Errorf("invalid percent base %q", percentBaseArg)
+	}
+
+	if percentBaseArg == "expected" && expectedTotal == 0 {
+		return 0, fmt.Errorf("--percent-base expected requires --expected-total")
+	}
+
+	if minStatus < 0 || minStatus > 3 {
+		return 0, fmt.Errorf("invalid minimum status %d", minStatus)
+	}
+
+	if trendRuns < 2 {
+		return 0, fmt.Errorf("invalid trend runs %d", trendRuns)
+	}
+
+	if precision < 0 {
+		return 0, fmt.Errorf("invalid precision %d", precision)
+	}
+
+	if eventState != "" && eventState != "passing" && eventState != "failing" && eventState != "flapping" {
+		return 0, fmt.Errorf("invalid state %q", eventState)
+	}
+
+	if metricsFormat != "" && metricsFormat != "graphite" && metricsFormat != "influx" && metricsFormat != "prometheus" {
+		return 0, fmt.Errorf("invalid metrics format %q", metricsFormat)
+	}
+
+	if jsonIncludeEvents != "none" && jsonIncludeEvents != "summary" && jsonIncludeEvents != "full" {
+		return 0, fmt.Errorf("invalid JSON events %q", jsonIncludeEvents)
+	}
+
+	if !validFormat(outputFormat) {
+		return 0, fmt.Errorf("invalid output format %q", outputFormat)
+	}
+
+	if !validFormat(fileFormat) {
+		return 0, fmt.Errorf("invalid file format %q", fileFormat)
+	}
+
+	if entityNameExpr != "" {
+		re, err := regexp.Compile(entityNameExpr)
+		if err != nil {
+			return 0, fmt.Errorf("invalid entity name regex: %v", err)
+		}
+		entityNameRe = re
+	}
+
+	if checkNameExpr != "" {
+		re, err := regexp.Compile(checkNameExpr)
+		if err != nil {
+			return 0, fmt.Errorf("invalid check name regex: %v", err)
+		}
+		checkNameRe = re
+	}
+
+	tmpl, err := template.New("message").Parse(messageTemplate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid message template: %v", err)
+	}
+	messageTmpl = tmpl
+
+	okTmpl, err = template.New("ok").Parse(okMessage)
+	if err != nil {
+		return 0, fmt.Errorf("invalid OK message: %v", err)
+	}
+
+	if classifyExpr != "" {
+		classifyVm = otto.New()
+
+		script, err := classifyVm.Compile("", classifyExpr)
+		if err != nil {
+			return 0, fmt.Errorf("invalid classify expression: %v", err)
+		}
+		classifyScript = script
+	}
+
+	err = initClient()
+	if err != nil {
+		return 0, err
+	}
+
+	if showQueries {
+		printQueries()
+		return 0, nil
+	}
+
+	return evalAggregate()
+}
+
+// redactUrl returns the URL with the password of any credentials redacted.
+func redactUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.User == nil {
+		return rawUrl
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+
+	return u.String()
+}
+
+// printQueries prints the authentication and Events API URLs that would be
+// requested, without requesting them.
+func printQueries() {
+	for _, backend := range backends {
+		if authMode != "bearer" {
+			fmt.Printf("auth mode: %s\n", authMode)
+		} else if tokenCommand != "" {
+			fmt.Printf("token command: %s\n", tokenCommand)
+		} else {
+			fmt.Printf("GET %s\n", redactUrl(authUrl(backend)))
+		}
+
+		if allNamespaces {
+			fmt.Printf("GET %s\n", redactUrl(fmt.Sprintf("%s/api/core/v2/namespaces", backend.Url)))
+			continue
+		}
+
+		if useGraphql {
+			for _, batch := range namespaceBatches(strings.Split(namespaces, ",")) {
+				fmt.Printf("POST %s (namespaces %s)\n", redactUrl(graphqlUrl(backend)), strings.Join(batch, ","))
+			}
+			continue
+		}
+
+		for _, namespace := range strings.Split(namespaces, ",") {
+			fmt.Printf("%s %s\n", queryMethod, redactUrl(firstPageUrl(backend, namespace, baseParams())))
+		}
+	}
+
+	if savedSearch != "" {
+		fmt.Printf("(the selectors of saved search %q are added to the Events API requests)\n", savedSearch)
+	}
+}
+
+// initClient configures the Sensu Go Backends and the HTTP transport used to
+// query them.
+func initClient() error {
+	backends = clusterBackends(parseBackends(backendArgs), clusters)
+
+	for _, backend := range backends {
+		if _, err := url.ParseRequestURI(authUrl(backend)); err != nil || !strings.HasPrefix(authPath, "/") {
+			return fmt.Errorf("invalid backend %q or auth path %q", backend.Url, authPath)
+		}
+
+		if requireTls && !strings.HasPrefix(backend.Url, "https://") {
+			return fmt.Errorf("backend %q does not use https, required by --require-tls", backend.Url)
+		}
+	}
+
+	initTransport()
+
+	if caCert == "" {
+		caCert = os.Getenv("SENSU_CA_CERT")
+	}
+
+	if apiKey == "" {
+		apiKey = os.Getenv("SENSU_API_KEY")
+	}
+
+	if authMode != "bearer" && authMode != "key" && authMode != "basic" {
+		return fmt.Errorf("invalid auth mode %q", authMode)
+	}
+
+	if authMode == "key" && apiKey == "" {
+		return fmt.Errorf("--auth-mode key requires --api-key or $SENSU_API_KEY")
+	}
+
+	if caCert != "" {
+		err := initCaData([]byte(caCert))
+		if err != nil {
+			return err
+		}
+	} else if caPath != "" {
+        err := initCa(caPath)
+        if err != nil {
+            return err
+        }
+    }
+
+	return nil
+}
+
+// readArgFile returns the trimmed content of the file for '@path' arguments,
+// other arguments are returned as is.
+func readArgFile(arg string) (string, error) {
+	if !strings.HasPrefix(arg, "@") {
+		return arg, nil
+	}
+
+	data, err := ioutil.ReadFile(strings.TrimPrefix(arg, "@"))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// normalizeNamespaces returns the comma- or newline-separated Namespaces as a
+// comma-delimited list, trimmed and without duplicates.
+func normalizeNamespaces(arg string) string {
+	seen := map[string]bool{}
+	result := []string{}
+
+	for _, value := range strings.FieldsFunc(arg, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if value = strings.TrimSpace(value); value != "" && !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+
+	return strings.Join(result, ",")
+}
+
+func initTransport() {
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+	defaultTransport.MaxIdleConns = maxIdleConns
+	defaultTransport.MaxIdleConnsPerHost = maxIdlePerHost
+	defaultTransport.IdleConnTimeout = idleTimeout
+	defaultTransport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: keepAlive,
+	}).DialContext
+
+	if tlsServerName != "" {
+		defaultTransport.TLSClientConfig = &tls.Config{
+			ServerName: tlsServerName,
+		}
+	}
+}
+
+func initCa(caPath string) error {
+	pemData, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return err
+	}
+
+	err = initCaData(pemData)
+	if err != nil {
+		return fmt.Errorf("CA file %s contained no valid certificates", caPath)
+	}
+
+	return nil
+}
+
+func initCaData(pemData []byte) error {
+   certs := x509.NewCertPool()
+	if !certs.AppendCertsFromPEM(pemData) {
+		return fmt.Errorf("CA certificate contained no valid certificates")
+	}
+
+   newTlsConfig := &tls.Config{}
+   newTlsConfig.RootCAs = certs
+	newTlsConfig.ServerName = tlsServerName
+
+   defaultTransport := http.DefaultTransport.(*http.Transport)
+   defaultTransport.TLSClientConfig = newTlsConfig
+   return nil
+}
+
+func parseBackends(backendArgs []string) []Backend {
+	if len(backendArgs) == 0 {
+		return []Backend{
+			{
+				Url:  fmt.Sprintf("%s://%s:%s", apiProto, apiHost, apiPort),
+				User: apiUser,
+				Pass: apiPass,
+			},
+		}
+	}
+
+	result := []Backend{}
+
+	for _, arg := range backendArgs {
+		backend := Backend{
+			User: apiUser,
+			Pass: apiPass,
+		}
+
+		parts := strings.SplitN(arg, ",", 3)
+		backend.Url = strings.TrimSuffix(parts[0], "/")
+		if len(parts) > 1 {
+			backend.User = parts[1]
+		}
+		if len(parts) > 2 {
+			backend.Pass = parts[2]
+		}
+
+		result = append(result, backend)
+	}
+
+	return result
+}
+
+func authUrl(backend Backend) string {
+	if backend.Gateway != "" {
+		return fmt.Sprintf("%s%s", backend.Gateway, authPath)
+	}
+
+	return fmt.Sprintf("%s%s", backend.Url, authPath)
+}
+
+// clusterBackends returns the --cluster member clusters of the backends, reached
+// through the backends as federation gateway with the --cluster-path.
+func clusterBackends(gateways []Backend, clusters []string) []Backend {
+	if len(clusters) == 0 {
+		return gateways
+	}
+
+	result := []Backend{}
+
+	for _, gateway := range gateways {
+		for _, cluster := range clusters {
+			path := strings.Replace(clusterPath, "{cluster}", url.PathEscape(cluster), -1)
+
+			result = append(result, Backend{
+				Url:     gateway.Url + strings.TrimSuffix(path, "/"),
+				User:    gateway.User,
+				Pass:    gateway.Pass,
+				Gateway: gateway.Url,
+			})
+		}
+	}
+
+	return result
+}
+
+// commandToken returns the access token printed by the --token-command.
+func commandToken(command string) (Auth, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return Auth{}, fmt.Errorf("token command failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	token := strings.TrimSpace(stdout.String())
+	if token == "" {
+		return Auth{}, fmt.Errorf("token command printed no token: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return Auth{AccessToken: token}, nil
+}
+
+// authenticate returns an access token for the backend, authenticating once
+// per backend.
+func authenticate(backend Backend) (Auth, error) {
+	key := authUrl(backend) + "|" + backend.User
+
+	if auth, ok := authCache[key]; ok {
+		return auth, nil
+	}
+
+	auth, err := requestAuth(backend)
+	if err == nil {
+		authCache[key] = auth
+	}
+
+	return auth, err
+}
+
+func requestAuth(backend Backend) (Auth, error) {
+	if authMode != "bearer" {
+		return Auth{}, nil
+	}
+
+	if tokenCommand != "" {
+		return commandToken(tokenCommand)
+	}
+
+	var auth Auth
+	req, err := http.NewRequest(
+		"GET",
+		authUrl(backend),
+		nil,
+	)
+	if err != nil {
+		return auth, err
+	}
+
+	req.SetBasicAuth(backend.User, backend.Pass)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return auth, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return auth, err
+	}
+
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&auth)
+
+	return auth, err
+}
+
+// authHeader returns the Authorization header for the requests to the backend
+// with the --auth-mode.
+func authHeader(backend Backend, auth Auth) (string, error) {
+	switch authMode {
+	case "key":
+		if apiKey == "" {
+			return "", fmt.Errorf("no API key for backend %s", backend.Url)
+		}
+		return fmt.Sprintf("Key %s", apiKey), nil
+	case "basic":
+		credentials := base64.StdEncoding.EncodeToString([]byte(backend.User + ":" + backend.Pass))
+		return fmt.Sprintf("Basic %s", credentials), nil
+	default:
+		if auth.AccessToken == "" {
+			return "", fmt.Errorf("no access token for backend %s", backend.Url)
+		}
+		return fmt.Sprintf("Bearer %s", auth.AccessToken), nil
+	}
+}
+
+func parseStatuses(arg string) (map[uint32]bool, error) {
+	statuses := map[uint32]bool{}
+
+	if strings.TrimSpace(arg) == "" {
+		return statuses, nil
+	}
+
+	for _, value := range strings.Split(arg, ",") {
+		status, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+		if err != nil {
+			return statuses, err
+		}
+		statuses[uint32(status)] = true
+	}
+
+	return statuses, nil
+}
+
+func parseLabelArg(labelArg string) (map[string]string, error) {
+	labels := map[string]string{}
+
+	if strings.TrimSpace(labelArg) == "" {
+		return labels, nil
+	}
+
+	pairs := strings.Split(labelArg, ",")
+
+	for _, pair := range pairs {
+		parts := strings.Split(strings.TrimSpace(pair), "=")
+		if len(parts) != 2 || parts[0] == "" {
+			return labels, fmt.Errorf("invalid label selector %q, expected 'key=value'", pair)
+		}
+		labels[parts[0]] = parts[1]
+	}
+
+	return labels, nil
+}
+
+// matchLabels returns whether the labels match every key=value of the
+// selector, ignoring the case of keys with --label-case-insensitive and of
+// values with --label-value-case-insensitive.
+func matchLabels(labels map[string]string, selector map[string]string) bool {
+	for key, value := range selector {
+		actual, ok := labels[key]
+
+		if !ok && labelKeyFold {
+			for label := range labels {
+				if strings.EqualFold(label, key) {
+					actual, ok = labels[label], true
+					break
+				}
+			}
+		}
+
+		if !ok {
+			return false
+		}
+
+		if actual != value && !(labelValueFold && strings.EqualFold(actual, value)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ignored returns whether the Check or Entity of the Event carries any of the
+// --ignore-annotation annotations.
+func ignored(event *types.Event) bool {
+	for key, value := range ignoreSelector {
+		if actual, ok := event.Check.ObjectMeta.Annotations[key]; ok && actual == value {
+			return true
+		}
+
+		if actual, ok := event.Entity.ObjectMeta.Annotations[key]; ok && actual == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseList(arg string) map[string]bool {
+	list := map[string]bool{}
+
+	for _, value := range strings.Split(arg, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list[value] = true
+		}
+	}
+
+	return list
+}
+
+// hasHook returns whether an Event has a hook execution matching --require-hook
+// and --hook-status.
+func hasHook(event *types.Event) bool {
+	for _, hook := range event.Check.Hooks {
+		if hook == nil {
+			continue
+		}
+
+		if requireHook != "" && hook.ObjectMeta.Name != requireHook {
+			continue
+		}
+
+		switch hookStatus {
+		case "ok":
+			if hook.Status != 0 {
+				continue
+			}
+		case "failing":
+			if hook.Status == 0 {
+				continue
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func filterEvents(events []*types.Event) []*types.Event {
+	result := []*types.Event{}
+
+	include := parseList(includeChecks)
+	exclude := parseList(excludeChecks)
+
+	for _, event := range events {
+		if event == nil || event.Check == nil || event.Entity == nil {
+			invalidEvents++
+			continue
+		}
+
+		reason := rejection(event, include, exclude)
+
+		if reason == "" {
+			result = append(result, event)
+		} else if traceFilter {
+			filterTrace[reason]++
+		}
+	}
+
+	return result
+}
+
+// rejection returns why an Event is filtered out, or an empty string when it
+// is selected.
+func rejection(event *types.Event, include map[string]bool, exclude map[string]bool) string {
+	if !matchLabels(event.Check.ObjectMeta.Labels, checkSelector) {
+		return labelMismatch("check-label", event.Check.ObjectMeta.Labels, checkSelector)
+	}
+
+	if !matchLabels(event.Entity.ObjectMeta.Labels, entitySelector) {
+		return labelMismatch("entity-label", event.Entity.ObjectMeta.Labels, entitySelector)
+	}
+
+	if maxEntityIdle != 0 && entityIdleAction == "drop" && entityIdle(event, maxEntityIdle) {
+		return "max-entity-idle"
+	}
+
+	if excludeDeregistering && deregistering(event) {
+		return "exclude-deregistering"
+	}
+
+	if eventState != "" && event.Check.State != eventState {
+		return "state"
+	}
+
+	if entityPlatform != "" && event.Entity.System.Platform != entityPlatform {
+		return "entity-platform"
+	}
+
+	if entityArch != "" && event.Entity.System.Arch != entityArch {
+		return "entity-arch"
+	}
+
+	if entityNameRe != nil && !entityNameRe.MatchString(event.Entity.ObjectMeta.Name) {
+		return "entity-name-regex"
+	}
+
+	if checkNameRe != nil && !checkNameRe.MatchString(event.Check.ObjectMeta.Name) {
+		return "check-name-regex"
+	}
+
+	if (requireHook != "" || hookStatus != "any") && !hasHook(event) {
+		return "require-hook"
+	}
+
+	if len(include) > 0 && !include[event.Check.ObjectMeta.Name] {
+		return "include-checks"
+	}
+
+	if exclude[event.Check.ObjectMeta.Name] {
+		return "exclude-checks"
+	}
+
+	if ignored(event) {
+		return "ignore-annotation"
+	}
+
+	if requireOutput && strings.TrimSpace(event.Check.Output) == "" {
+		emptyOutputEvents++
+		return "require-output"
+	}
+
+	return ""
+}
+
+// labelMismatch returns the first label of the selector, in alphabetical
+// order, that the labels do not match with --trace-filter.
+func labelMismatch(kind string, labels map[string]string, selector map[string]string) string {
+	if !traceFilter {
+		return kind
+	}
+
+	keys := []string{}
+	for key := range selector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !matchLabels(labels, map[string]string{key: selector[key]}) {
+			return fmt.Sprintf("%s %s=%s", kind, key, selector[key])
+		}
+	}
+
+	return kind
+}
+
+// compareVersions compares dotted numeric versions, ignoring any pre-release or
+// build suffix, and returns -1, 0 or 1.
+func compareVersions(a string, b string) int {
+	parse := func(version string) []int {
+		version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+		if i := strings.IndexAny(version, "-+#"); i >= 0 {
+			version = version[:i]
+		}
+
+		result := []int{}
+		for _, part := range strings.Split(version, ".") {
+			value, _ := strconv.Atoi(part)
+			result = append(result, value)
+		}
+		return result
+	}
+
+	pa, pb := parse(a), parse(b)
+
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var va, vb int
+		if i < len(pa) {
+			va = pa[i]
+		}
+		if i < len(pb) {
+			vb = pb[i]
+		}
+		if va < vb {
+			return -1
+		}
+		if va > vb {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// satisfiesVersion returns whether a version satisfies a comma-delimited list
+// of constraints such as '>=5.10,<6'.
+func satisfiesVersion(version string, constraints string) (bool, error) {
+	for _, constraint := range strings.Split(constraints, ",") {
+		constraint = strings.TrimSpace(constraint)
+
+		operator := strings.TrimRight(constraint, "0123456789.v")
+		if operator == constraint {
+			return false, fmt.Errorf("invalid version constraint %q", constraint)
+		}
+
+		cmp := compareVersions(version, strings.TrimPrefix(constraint, operator))
+
+		var ok bool
+		switch strings.TrimSpace(operator) {
+		case ">=":
+			ok = cmp >= 0
+		case ">":
+			ok = cmp > 0
+		case "<=":
+			ok = cmp <= 0
+		case "<":
+			ok = cmp < 0
+		case "", "=", "==":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		default:
+			return false, fmt.Errorf("invalid version constraint %q", constraint)
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func checkVersion(backend Backend, auth Auth) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/version", backend.Url), nil)
+	if err != nil {
+		return err
+	}
+
+	header, err := authHeader(backend, auth)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", header)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var version struct {
+		SensuBackend string `json:"sensu_backend"`
+	}
+
+	err = json.Unmarshal(body, &version)
+	if err != nil {
+		return fmt.Errorf("failed to read the version of backend %s: %v", backend.Url, err)
+	}
+
+	ok, err := satisfiesVersion(version.SensuBackend, requireVersion)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("backend %s version %q does not satisfy %q", backend.Url, version.SensuBackend, requireVersion)
+	}
+
+	return nil
+}
+
+func getSilenced(backend Backend, auth Auth, namespace string, name string) (*types.Silenced, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/core/v2/namespaces/%s/silenced/%s", backend.Url, namespace, name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := authHeader(backend, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", header)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get silenced entry %q: %s", name, resp.Status)
+	}
+
+	silenced := &types.Silenced{}
+
+	err = json.Unmarshal(body, silenced)
+
+	return silenced, err
+}
+
+// getMaintenance returns the --maintenance-silence entry when it is active in
+// any of the queried namespaces.
+func getMaintenance() (*types.Silenced, error) {
+	for _, backend := range backends {
+		auth, err := authenticate(backend)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, namespace := range strings.Split(namespaces, ",") {
+			silenced, err := getSilenced(backend, auth, namespace, maintenanceSilence)
+			if err != nil {
+				return nil, err
+			}
+
+			if silenced != nil && silenced.Begin <= time.Now().Unix() {
+				if silenced.ObjectMeta.Namespace == "" {
+					silenced.ObjectMeta.Namespace = namespace
+				}
+				return silenced, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func getNamespaces(backend Backend, auth Auth) ([]*types.Namespace, error) {
+	url := fmt.Sprintf("%s/api/core/v2/namespaces", backend.Url)
+	namespaces := []*types.Namespace{}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return namespaces, err
+	}
+
+	header, err := authHeader(backend, auth)
+	if err != nil {
+		return namespaces, err
+	}
+
+	req.Header.Set("Authorization", header)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return namespaces, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return namespaces, err
+	}
+
+	err = json.Unmarshal(body, &namespaces)
+
+	return namespaces, err
+}
+
+// getNamespaceMetas returns the metadata, including the labels, of the
+// Namespaces visible to the API User.
+func getNamespaceMetas(backend Backend, auth Auth) ([]types.ObjectMeta, error) {
+	metas := []types.ObjectMeta{}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/core/v3/namespaces", backend.Url), nil)
+	if err != nil {
+		return metas, err
+	}
+
+	header, err := authHeader(backend, auth)
+	if err != nil {
+		return metas, err
+	}
+
+	req.Header.Set("Authorization", header)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return metas, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return metas, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return metas, fmt.Errorf("failed to get namespaces: %s", resp.Status)
+	}
+
+	namespaces := []struct {
+		Metadata types.ObjectMeta `json:"metadata"`
+	}{}
+
+	err = json.Unmarshal(body, &namespaces)
+	if err != nil {
+		return metas, err
+	}
+
+	for _, namespace := range namespaces {
+		metas = append(metas, namespace.Metadata)
+	}
+
+	return metas, nil
+}
+
+// queryNamespaces returns the Namespaces to query, the --namespaces or with
+// --all-namespaces every Namespace matching the --namespace-labels.
+func queryNamespaces(backend Backend, auth Auth) ([]string, error) {
+	if !allNamespaces {
+		return strings.Split(namespaces, ","), nil
+	}
+
+	if len(namespaceSelector) == 0 {
+		list, err := getNamespaces(backend, auth)
+		if err != nil {
+			return nil, err
+		}
+
+		names := []string{}
+		for _, namespace := range list {
+			names = append(names, namespace.Name)
+		}
+
+		return names, nil
+	}
+
+	metas, err := getNamespaceMetas(backend, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+
+	for _, meta := range metas {
+		if matchLabels(meta.Labels, namespaceSelector) {
+			names = append(names, meta.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// firstPageUrl returns the URL of the first Events API request for a
+// namespace, limited to the --page-size.
+func firstPageUrl(backend Backend, namespace string, params url.Values) string {
+	if pageSize > 0 {
+		params.Set("limit", strconv.Itoa(pageSize))
+	}
+
+	return eventsUrl(backend, namespace, params)
+}
+
+func eventsUrl(backend Backend, namespace string, params url.Values) string {
+	eventsUrl := fmt.Sprintf("%s/api/core/v2/namespaces/%s/events", backend.Url, namespace)
+
+	if len(params) > 0 {
+		eventsUrl += "?" + params.Encode()
+	}
+
+	return eventsUrl
+}
+
+// searchParams translates the parameters of a saved search into Events API
+// query parameters.
+func searchParams(parameters []string) (url.Values, error) {
+	params := url.Values{}
+	fieldSelectors := []string{}
+
+	for _, parameter := range parameters {
+		parts := strings.SplitN(parameter, ":", 2)
+		if len(parts) != 2 {
+			return params, fmt.Errorf("unsupported saved search parameter %q", parameter)
+		}
+
+		switch parts[0] {
+		case "labelSelector":
+			params.Add("labelSelector", parts[1])
+		case "fieldSelector":
+			fieldSelectors = append(fieldSelectors, parts[1])
+		case "check":
+			fieldSelectors = append(fieldSelectors, fmt.Sprintf("event.check.name == %q", parts[1]))
+		case "entity":
+			fieldSelectors = append(fieldSelectors, fmt.Sprintf("event.entity.name == %q", parts[1]))
+		default:
+			return params, fmt.Errorf("unsupported saved search parameter %q", parameter)
+		}
+	}
+
+	if len(fieldSelectors) > 0 {
+		params.Add("fieldSelector", strings.Join(fieldSelectors, " && "))
+	}
+
+	return params, nil
+}
+
+func getSavedSearch(backend Backend, auth Auth, namespace string, name string) (url.Values, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/enterprise/searches/v1/namespaces/%s/searches/%s", backend.Url, namespace, name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := authHeader(backend, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", header)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("saved search %q does not exist in namespace %q", name, namespace)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get saved search %q: %s", name, resp.Status)
+	}
+
+	var search struct {
+		Spec struct {
+			Parameters []string `json:"parameters"`
+		} `json:"spec"`
+	}
+
+	err = json.Unmarshal(body, &search)
+	if err != nil {
+		return nil, err
+	}
+
+	return searchParams(search.Spec.Parameters)
+}
+
+// baseParams returns the Events API query parameters that do not require a
+// request to the Sensu Go Backend API.
+func baseParams() url.Values {
+	params := url.Values{}
+
+	for key, values := range queryParams {
+		params[key] = append([]string{}, values...)
+	}
+
+	if eventState != "" {
+		addFieldSelector(params, fmt.Sprintf("event.check.state == %s", eventState))
+	}
+
+	return params
+}
+
+// eventsParams returns the query parameters of the Events API request for a
+// namespace, combining --query with the --saved-search selectors.
+func eventsParams(backend Backend, auth Auth, namespace string) (url.Values, error) {
+	params := baseParams()
+
+	if savedSearch == "" {
+		return params, nil
+	}
+
+	search, err := getSavedSearch(backend, auth, namespace, savedSearch)
+	if err != nil {
+		return params, err
+	}
+
+	for key, values := range search {
+		if key == "fieldSelector" {
+			addFieldSelector(params, values[0])
+			continue
+		}
+		params[key] = append(params[key], values...)
+	}
+
+	return params, nil
+}
+
+// addFieldSelector adds a field selector to the parameters, combined with any
+// field selector already present.
+func addFieldSelector(params url.Values, selector string) {
+	if params.Get("fieldSelector") != "" {
+		selector = fmt.Sprintf("%s && %s", params.Get("fieldSelector"), selector)
+	}
+
+	params.Set("fieldSelector", selector)
+}
+
+// decodeEvents decodes a JSON array of Events, rejecting fields unknown to the
+// Event types with --strict-decode.
+func decodeEvents(body []byte, events *[]*types.Event) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+
+	if strictDecode {
+		decoder.DisallowUnknownFields()
+	}
+
+	err := decoder.Decode(events)
+	if err != nil && strictDecode {
+		return fmt.Errorf("failed to strictly decode Events: %v", err)
+	}
+
+	return err
+}
+
+// eventsEnvelope is a page of Events wrapped in an object, with the
+// continue token in the body instead of the Sensu-Continue header.
+type eventsEnvelope struct {
+	Items  json.RawMessage `json:"items"`
+	Events json.RawMessage `json:"events"`
+	Next   string          `json:"next"`
+	Meta   struct {
+		Continue string `json:"continue"`
+	} `json:"meta"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+// getEventsPage returns a page of Events and the continue token of the next
+// page, from the Sensu-Continue header or an enveloped body.
+func getEventsPage(backend Backend, page string, auth Auth) ([]*types.Event, string, error) {
+	events := []*types.Event{}
+
+	var payload io.Reader
+	if queryBody != "" {
+		payload = strings.NewReader(queryBody)
+	}
+
+	req, err := http.NewRequest(queryMethod, page, payload)
+	if err != nil {
+		return events, "", err
+	}
+	req = req.WithContext(deadlineCtx)
+
+	header, err := authHeader(backend, auth)
+	if err != nil {
+		return events, "", err
+	}
+
+	req.Header.Set("Authorization", header)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return events, "", err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return events, "", err
+	}
+
+	if resp.StatusCode >= 400 && len(bytes.TrimSpace(body)) == 0 {
+		return events, "", fmt.Errorf("failed to get Events: %s", resp.Status)
+	}
+
+	next := resp.Header.Get("Sensu-Continue")
+
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		envelope := eventsEnvelope{}
+
+		err = json.Unmarshal(trimmed, &envelope)
+		if err != nil {
+			return events, "", err
+		}
+
+		body = envelope.Items
+		if body == nil {
+			body = envelope.Events
+		}
+
+		if body == nil && (envelope.Message != "" || envelope.Error != "") {
+			message := strings.TrimSpace(envelope.Error + " " + envelope.Message)
+			return events, "", fmt.Errorf("failed to get Events: %s (code %d)", message, envelope.Code)
+		}
+
+		if body == nil {
+			body = []byte("null")
+		}
+
+		if next == "" {
+			next = envelope.Meta.Continue
+		}
+		if next == "" {
+			next = envelope.Next
+		}
+	}
+
+	err = decodeEvents(body, &events)
+
+	// Some versions return null instead of an empty array.
+	if events == nil {
+		events = []*types.Event{}
+	}
+
+	return events, next, err
+}
+
+func getEvents(backend Backend, auth Auth, namespace string) ([]*types.Event, error) {
+	events := []*types.Event{}
+
+	params, err := eventsParams(backend, auth, namespace)
+	if err != nil {
+		return events, err
+	}
+
+	url := firstPageUrl(backend, namespace, params)
+
+	if cached, ok := eventCache[url]; ok {
+		return filterEvents(cached), nil
+	}
+
+	for page := url; page != ""; {
+		var selected []*types.Event
+		var next string
+
+		selected, next, err = getEventsPage(backend, page, auth)
+		if err != nil {
+			return events, err
+		}
+
+		events = append(events, selected...)
+
+		page = ""
+		if next != "" && next != params.Get("continue") {
+			params.Set("continue", next)
+			page = eventsUrl(backend, namespace, params)
+		}
+	}
+
+	eventCache[url] = events
+
+	result := filterEvents(events)
+
+	return result, err
+}
+
+const graphqlEventFields = `
+  timestamp
+  entity {
+    metadata { name namespace labels { key val } annotations { key val } }
+    system { platform arch }
+    entityClass
+    subscriptions
+    lastSeen
+    deregister
+  }
+  check {
+    metadata { name namespace labels { key val } annotations { key val } }
+    command
+    interval
+    status
+    output
+    state
+    executed
+    lastOK
+    history { status executed }
+    hooks { config { name } status }
+  }`
+
+type graphqlPair struct {
+	Key string `json:"key"`
+	Val string `json:"val"`
+}
+
+type graphqlMeta struct {
+	Name        string        `json:"name"`
+	Namespace   string        `json:"namespace"`
+	Labels      []graphqlPair `json:"labels"`
+	Annotations []graphqlPair `json:"annotations"`
+}
+
+type graphqlEvent struct {
+	Timestamp string `json:"timestamp"`
+	Entity    struct {
+		Metadata graphqlMeta `json:"metadata"`
+		System   struct {
+			Platform string `json:"platform"`
+			Arch     string `json:"arch"`
+		} `json:"system"`
+		EntityClass   string   `json:"entityClass"`
+		Subscriptions []string `json:"subscriptions"`
+		LastSeen      string   `json:"lastSeen"`
+		Deregister    bool     `json:"deregister"`
+	} `json:"entity"`
+	Check struct {
+		Metadata graphqlMeta `json:"metadata"`
+		Command  string      `json:"command"`
+		Interval uint32      `json:"interval"`
+		Status   uint32      `json:"status"`
+		Output   string      `json:"output"`
+		State    string      `json:"state"`
+		Executed string      `json:"executed"`
+		LastOK   string      `json:"lastOK"`
+		History  []struct {
+			Status   uint32 `json:"status"`
+			Executed string `json:"executed"`
+		} `json:"history"`
+		Hooks []struct {
+			Config struct {
+				Name string `json:"name"`
+			} `json:"config"`
+			Status int32 `json:"status"`
+		} `json:"hooks"`
+	} `json:"check"`
+}
+
+type graphqlResponse struct {
+	Data map[string]*struct {
+		Events struct {
+			Nodes    []graphqlEvent `json:"nodes"`
+			PageInfo struct {
+				HasNextPage bool `json:"hasNextPage"`
+				NextOffset  int  `json:"nextOffset"`
+			} `json:"pageInfo"`
+		} `json:"events"`
+	} `json:"data"`
+	Errors []struct {
+		Message string        `json:"message"`
+		Path    []interface{} `json:"path"`
+	} `json:"errors"`
+}
+
+func graphqlTime(value string) int64 {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0
+	}
+
+	return parsed.Unix()
+}
+
+func graphqlObjectMeta(meta graphqlMeta) types.ObjectMeta {
+	result := types.ObjectMeta{
+		Name:        meta.Name,
+		Namespace:   meta.Namespace,
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+	}
+
+	for _, pair := range meta.Labels {
+		result.Labels[pair.Key] = pair.Val
+	}
+
+	for _, pair := range meta.Annotations {
+		result.Annotations[pair.Key] = pair.Val
+	}
+
+	return result
+}
+
+func (node graphqlEvent) toEvent(namespace string) *types.Event {
+	event := &types.Event{
+		Timestamp: graphqlTime(node.Timestamp),
+		ObjectMeta: types.ObjectMeta{
+			Namespace: namespace,
+		},
+		Entity: &types.Entity{
+			ObjectMeta:    graphqlObjectMeta(node.Entity.Metadata),
+			EntityClass:   node.Entity.EntityClass,
+			Subscriptions: node.Entity.Subscriptions,
+			LastSeen:      graphqlTime(node.Entity.LastSeen),
+			Deregister:    node.Entity.Deregister,
+			System: types.System{
+				Platform: node.Entity.System.Platform,
+				Arch:     node.Entity.System.Arch,
+			},
+		},
+		Check: &types.Check{
+			ObjectMeta: graphqlObjectMeta(node.Check.Metadata),
+			Command:    node.Check.Command,
+			Interval:   node.Check.Interval,
+			Status:     node.Check.Status,
+			Output:     node.Check.Output,
+			State:      node.Check.State,
+			Executed:   graphqlTime(node.Check.Executed),
+			LastOK:     graphqlTime(node.Check.LastOK),
+		},
+	}
+
+	for _, history := range node.Check.History {
+		event.Check.History = append(event.Check.History, types.CheckHistory{
+			Status:   history.Status,
+			Executed: graphqlTime(history.Executed),
+		})
+	}
+
+	for _, hook := range node.Check.Hooks {
+		event.Check.Hooks = append(event.Check.Hooks, &types.Hook{
+			HookConfig: types.HookConfig{ObjectMeta: types.ObjectMeta{Name: hook.Config.Name}},
+			Status:     hook.Status,
+		})
+	}
+
+	return event
+}
+
+// graphqlQuery returns the GraphQL query of a page of Events of the namespaces
+// with an offset, by index, using an alias per namespace.
+func graphqlQuery(namespaces []string, offsets map[int]int) string {
+	query := "query {"
+
+	for i, namespace := range namespaces {
+		if offset, ok := offsets[i]; ok {
+			query += fmt.Sprintf("\n ns%d: namespace(name: %q) { events(offset: %d, limit: %d) { nodes { %s } pageInfo { hasNextPage nextOffset } } }", i, namespace, offset, graphqlLimit, graphqlEventFields)
+		}
+	}
+
+	return query + "\n}"
+}
+
+// firstOffsets returns the offsets of the first page of Events of the
+// namespaces, by index.
+func firstOffsets(namespaces []string) map[int]int {
+	offsets := map[int]int{}
+
+	for i := range namespaces {
+		offsets[i] = 0
+	}
+
+	return offsets
+}
+
+// graphqlUrl returns the URL of the GraphQL API of the backend.
+func graphqlUrl(backend Backend) string {
+	return fmt.Sprintf("%s/graphql", backend.Url)
+}
+
+// postGraphql posts the query to the GraphQL API of the backend.
+func postGraphql(backend Backend, auth Auth, query string) (graphqlResponse, error) {
+	var response graphqlResponse
+
+	data, err := json.Marshal(map[string]string{"query": query})
+	if err != nil {
+		return response, err
+	}
+
+	req, err := http.NewRequest("POST", graphqlUrl(backend), bytes.NewReader(data))
+	if err != nil {
+		return response, err
+	}
+	req = req.WithContext(deadlineCtx)
+
+	header, err := authHeader(backend, auth)
+	if err != nil {
+		return response, err
+	}
+
+	req.Header.Set("Authorization", header)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return response, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return response, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return response, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	err = json.Unmarshal(body, &response)
+
+	return response, err
+}
+
+// getGraphqlEvents queries the Events of all namespaces through the Sensu Go
+// GraphQL API, in a single request per page of graphqlLimit Events. The next
+// pages are requested for the namespaces with more Events only. The errors of
+// failed namespaces are recorded unless --strict is set, it only fails when all
+// namespaces failed.
+func getGraphqlEvents(backend Backend, auth Auth, namespaces []string, failed map[string]string) ([]*types.Event, error) {
+	events := []*types.Event{}
+	errors := map[string]string{}
+
+	for offsets := firstOffsets(namespaces); len(offsets) > 0; {
+		response, err := postGraphql(backend, auth, graphqlQuery(namespaces, offsets))
+		if err != nil {
+			return events, err
+		}
+
+		messages := map[string]string{}
+
+		for _, e := range response.Errors {
+			if len(e.Path) == 0 {
+				return events, fmt.Errorf("%s", e.Message)
+			}
+			messages[fmt.Sprint(e.Path[0])] = e.Message
+		}
+
+		next := map[int]int{}
+
+		for i, namespace := range namespaces {
+			offset, ok := offsets[i]
+			if !ok {
+				continue
+			}
+
+			alias := fmt.Sprintf("ns%d", i)
+			data := response.Data[alias]
+
+			message, ok := messages[alias]
+			if !ok && data == nil {
+				message, ok = "namespace not found", true
+			}
+
+			if ok && strict {
+				return events, fmt.Errorf("failed to get Events of namespace %s: %s", namespace, message)
+			}
+
+			if ok {
+				errors[namespace] = message
+				continue
+			}
+
+			for _, node := range data.Events.Nodes {
+				events = append(events, node.toEvent(namespace))
+			}
+
+			if info := data.Events.PageInfo; info.HasNextPage && info.NextOffset > offset {
+				next[i] = info.NextOffset
+			}
+		}
+
+		offsets = next
+	}
+
+	if len(errors) == len(namespaces) {
+		return events, fmt.Errorf("failed to get the Events of all namespaces")
+	}
+
+	for namespace, message := range errors {
+		recordFailure(failed, backend, namespace, message)
+	}
+
+	return filterEvents(events), nil
+}
+
+func oldestEvent(events []*types.Event) *types.Event {
+	var oldest *types.Event
+
+	for _, event := range events {
+		if oldest == nil || event.Timestamp < oldest.Timestamp {
+			oldest = event
+		}
+	}
+
+	return oldest
+}
+
+func readEvents(path string) ([]*types.Event, error) {
+	events := []*types.Event{}
+
+	var body []byte
+	var err error
+
+	if path == "-" {
+		body, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		body, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return events, err
+	}
+
+	err = decodeEvents(body, &events)
+	if err != nil {
+		return events, err
+	}
+
+	return filterEvents(events), nil
+}
+
+func jitterDelay(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	return time.Duration(random.Int63n(int64(max)))
+}
+
+// tagEvent annotates an Event with where it was retrieved from.
+func tagEvent(event *types.Event, key string, value string) {
+	if event.ObjectMeta.Annotations == nil {
+		event.ObjectMeta.Annotations = map[string]string{}
+	}
+
+	event.ObjectMeta.Annotations[key] = value
+}
+
+// fetchEvents returns the Events of all backends and the errors of the
+// Namespaces that failed, by Namespace, within the deadline of the context.
+func fetchEvents(ctx context.Context) ([]*types.Event, map[string]string, error) {
+	events := []*types.Event{}
+	failed := map[string]string{}
+
+	deadlineCtx = ctx
+	queriedNamespaces = []string{}
+
+	for _, backend := range backends {
+		auth, err := authenticate(backend)
+
+		if err != nil {
+			return events, failed, err
+		}
+
+		if credWarnWindow > 0 {
+			if notice := credentialNotice(backend, auth); notice != "" {
+				notices = append(notices, notice)
+			}
+		}
+
+		if requireVersion != "" {
+			err = checkVersion(backend, auth)
+			if err != nil {
+				return events, failed, err
+			}
+		}
+
+		queried, err := queryNamespaces(backend, auth)
+		if err != nil {
+			return events, failed, err
+		}
+
+		for _, namespace := range queried {
+			if len(backends) > 1 {
+				namespace = fmt.Sprintf("%s/%s", backend.Url, namespace)
+			}
+			queriedNamespaces = append(queriedNamespaces, namespace)
+		}
+
+		if shuffleNamespaces {
+			random := rand.New(rand.NewSource(time.Now().UnixNano()))
+			random.Shuffle(len(queried), func(i, j int) {
+				queried[i], queried[j] = queried[j], queried[i]
+			})
+		}
+
+		var selected []*types.Event
+
+		if useGraphql {
+			selected, err = getGraphqlBatches(backend, auth, queried, failed)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: GraphQL query failed, falling back to the REST API: %v\n", err)
+			}
+		}
+
+		if !useGraphql || err != nil {
+			selected, err = getBackendEvents(backend, auth, queried, failed)
+		}
+
+		if err != nil {
+			return events, failed, err
+		}
+
+		for _, event := range selected {
+			if len(backends) > 1 {
+				tagEvent(event, backendAnnotation, backend.Url)
+			}
+			events = append(events, event)
+		}
+	}
+
+	return events, failed, nil
+}
+
+// credentialNotice returns a notice when the credentials of the backend expire
+// within the --cred-warn-window, at the --cred-expiry or the token expiry.
+func credentialNotice(backend Backend, auth Auth) string {
+	if credExpiresAt.IsZero() && auth.ExpiresAt == 0 {
+		return ""
+	}
+
+	expiresAt := time.Unix(auth.ExpiresAt, 0)
+	if !credExpiresAt.IsZero() {
+		expiresAt = credExpiresAt
+	}
+
+	left := time.Until(expiresAt).Round(time.Second)
+	if left > credWarnWindow {
+		return ""
+	}
+
+	if left <= 0 {
+		return fmt.Sprintf("the credentials for %s expired at %s", backend.Url, expiresAt.Format(time.RFC3339))
+	}
+
+	return fmt.Sprintf("the credentials for %s expire in %s (%s)", backend.Url, left, expiresAt.Format(time.RFC3339))
+}
+
+// parseExpiry parses the --cred-expiry as an RFC 3339 time or a date, the zero
+// time when it is not set.
+func parseExpiry(arg string) (time.Time, error) {
+	if arg == "" {
+		return time.Time{}, nil
+	}
+
+	expiry, err := time.Parse(time.RFC3339, arg)
+	if err != nil {
+		expiry, err = time.Parse("2006-01-02", arg)
+	}
+
+	return expiry, err
+}
+
+// batchDelay sleeps for the --namespace-batch-delay before the Namespace at
+// this index when it starts a new batch of --namespace-batch-size.
+func batchDelay(index int) {
+	if namespaceBatchSize > 0 && index > 0 && index%namespaceBatchSize == 0 {
+		time.Sleep(namespaceBatchDelay)
+	}
+}
+
+// getGraphqlBatches queries the Events of the Namespaces through the GraphQL
+// API, in a request per batch of --namespace-batch-size Namespaces.
+func getGraphqlBatches(backend Backend, auth Auth, namespaces []string, failed map[string]string) ([]*types.Event, error) {
+	events := []*types.Event{}
+
+	for i, batch := range namespaceBatches(namespaces) {
+		if i > 0 {
+			time.Sleep(namespaceBatchDelay)
+		}
+
+		selected, err := getGraphqlEvents(backend, auth, batch, failed)
+		if err != nil {
+			return events, err
+		}
+
+		events = append(events, selected...)
+	}
+
+	return events, nil
+}
+
+// namespaceBatches splits the Namespaces in batches of --namespace-batch-size,
+// or a single batch without batching.
+func namespaceBatches(namespaces []string) [][]string {
+	if namespaceBatchSize <= 0 {
+		return [][]string{namespaces}
+	}
+
+	batches := [][]string{}
+
+	for start := 0; start < len(namespaces); start += namespaceBatchSize {
+		end := start + namespaceBatchSize
+		if end > len(namespaces) {
+			end = len(namespaces)
+		}
+
+		batches = append(batches, namespaces[start:end])
+	}
+
+	return batches
+}
+
+// getBackendEvents returns the Events of the Namespaces, recording the errors
+// of failed Namespaces unless --strict is set. It only fails when all
+// Namespaces failed before the --deadline was exceeded.
+func getBackendEvents(backend Backend, auth Auth, namespaces []string, failed map[string]string) ([]*types.Event, error) {
+	events := []*types.Event{}
+
+	var lastErr error
+	errors := 0
+
+	for i, namespace := range namespaces {
+		batchDelay(i)
+
+		var selected []*types.Event
+		err := deadlineCtx.Err()
+		if err == nil {
+			selected, err = getEvents(backend, auth, namespace)
+		}
+
+		if err != nil && strict {
+			return events, err
+		}
+
+		if err != nil {
+			lastErr = err
+			errors++
+			recordFailure(failed, backend, namespace, err.Error())
+			continue
+		}
+
+		for _, event := range selected {
+			events = append(events, event)
+		}
+	}
+
+	if errors == len(namespaces) && deadlineCtx.Err() != context.DeadlineExceeded {
+		return events, lastErr
+	}
+
+	return events, nil
+}
+
+// recordFailure records the error of a Namespace that failed, by backend and
+// Namespace when aggregating multiple backends.
+func recordFailure(failed map[string]string, backend Backend, namespace string, message string) {
+	if len(backends) > 1 {
+		failed[fmt.Sprintf("%s/%s", backend.Url, namespace)] = message
+	} else {
+		failed[namespace] = message
+	}
+}
+
+// entityIdle returns whether the Entity of an Event was last seen longer than
+// the duration ago.
+func entityIdle(event *types.Event, idle time.Duration) bool {
+	return event.Entity.LastSeen != 0 && time.Since(time.Unix(event.Entity.LastSeen, 0)) > idle
+}
+
+// deregistering returns whether the Entity of an Event is an agent that is
+// deregistered by Sensu Go, because it has deregistration enabled and was last
+// seen longer than the --deregister-after keepalive timeout ago.
+func deregistering(event *types.Event) bool {
+	return event.Entity.Deregister && event.Entity.EntityClass == "agent" && entityIdle(event, deregisterAfter)
+}
+
+// overdueEvents returns the Events older than the factor times the interval of
+// their Check. Events of Checks without an interval, e.g. cron, are skipped.
+func overdueEvents(events []*types.Event, factor float64) []string {
+	result := []string{}
+
+	for _, event := range events {
+		if event.Check.Interval == 0 || event.Timestamp == 0 {
+			continue
+		}
+
+		age := time.Since(time.Unix(event.Timestamp, 0))
+		if age.Seconds() > factor*float64(event.Check.Interval) {
+			result = append(result, fmt.Sprintf("%s/%s (%s)", event.Entity.ObjectMeta.Name, event.Check.ObjectMeta.Name, age.Round(time.Second)))
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// idleEntities returns the Entities last seen longer than the duration ago.
+func idleEntities(events []*types.Event, idle time.Duration) []string {
+	seen := map[string]bool{}
+	result := []string{}
+
+	for _, event := range events {
+		name := event.Entity.ObjectMeta.Name
+		if !seen[name] && entityIdle(event, idle) {
+			result = append(result, fmt.Sprintf("%s (%s)", name, time.Since(time.Unix(event.Entity.LastSeen, 0)).Round(time.Second)))
+		}
+		seen[name] = true
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// failingCritical returns the Entities subscribed to any of the subscriptions
+// with an Event that is not OK.
+func failingCritical(events []*types.Event, subscriptions map[string]bool) []string {
+	failing := map[string]bool{}
+
+	for _, event := range events {
+		if eventOk(event) {
+			continue
+		}
+
+		for _, subscription := range event.Entity.Subscriptions {
+			if subscriptions[subscription] {
+				failing[fmt.Sprintf("%s/%s", event.Entity.ObjectMeta.Name, event.Check.ObjectMeta.Name)] = true
+				break
+			}
+		}
+	}
+
+	result := []string{}
+	for name := range failing {
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// latestEvents returns the most recent Event of each namespace, Entity and
+// Check, in the order they were first returned.
+func latestEvents(events []*types.Event) []*types.Event {
+	latest := map[string]int{}
+	result := []*types.Event{}
+
+	for _, event := range events {
+		key := fmt.Sprintf("%s/%s/%s", namespaceOf(event), event.Entity.ObjectMeta.Name, event.Check.ObjectMeta.Name)
+
+		if i, ok := latest[key]; ok {
+			if event.Timestamp > result[i].Timestamp {
+				result[i] = event
+			}
+			continue
+		}
+
+		latest[key] = len(result)
+		result = append(result, event)
+	}
+
+	return result
+}
+
+// healthyEntities returns the Entities of which all Events are OK.
+func healthyEntities(events []*types.Event) []string {
+	healthy := map[string]bool{}
+
+	for _, event := range events {
+		name := event.Entity.ObjectMeta.Name
+		if ok, seen := healthy[name]; !seen || ok {
+			healthy[name] = eventOk(event)
+		}
+	}
+
+	result := []string{}
+	for name, ok := range healthy {
+		if ok {
+			result = append(result, name)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// partialOutages returns the Checks that are OK on some Entities but critical
+// on others.
+func partialOutages(events []*types.Event) []string {
+	ok := map[string]int{}
+	critical := map[string]int{}
+
+	for _, event := range events {
+		name := event.Check.ObjectMeta.Name
+		switch {
+		case eventOk(event):
+			ok[name]++
+		case eventStatus(event) == 2:
+			critical[name]++
+		}
+	}
+
+	result := []string{}
+
+	for name, count := range critical {
+		if ok[name] > 0 {
+			result = append(result, fmt.Sprintf("%s (%d/%d critical)", name, count, count+ok[name]))
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func deficientEntities(events []*types.Event, min int) []string {
+	entityChecks := map[string]map[string]bool{}
+
+	for _, event := range events {
+		name := event.Entity.ObjectMeta.Name
+		if entityChecks[name] == nil {
+			entityChecks[name] = map[string]bool{}
+		}
+		entityChecks[name][event.Check.ObjectMeta.Name] = true
+	}
+
+	result := []string{}
+
+	for name, checks := range entityChecks {
+		if len(checks) < min {
+			result = append(result, fmt.Sprintf("%s (%d)", name, len(checks)))
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// parseCoverage parses --require-check-coverage, e.g. 'security-agent=95'.
+func parseCoverage(arg string) (map[string]float64, error) {
+	coverage := map[string]float64{}
+
+	if strings.TrimSpace(arg) == "" {
+		return coverage, nil
+	}
+
+	for _, pair := range strings.Split(arg, ",") {
+		parts := strings.Split(strings.TrimSpace(pair), "=")
+		if len(parts) != 2 || parts[0] == "" {
+			return coverage, fmt.Errorf("invalid check coverage %q, expected 'check=percent'", pair)
+		}
+
+		percent, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return coverage, fmt.Errorf("invalid check coverage percent %q", parts[1])
+		}
+
+		coverage[parts[0]] = percent
+	}
+
+	return coverage, nil
+}
+
+// checkCoverage returns the percentage of the Entities of the Events that have
+// an Event for the Check.
+func checkCoverage(events []*types.Event, check string) float64 {
+	entities := map[string]bool{}
+
+	for _, event := range events {
+		name := event.Entity.ObjectMeta.Name
+		entities[name] = entities[name] || event.Check.ObjectMeta.Name == check
+	}
+
+	if len(entities) == 0 {
+		return 100
+	}
+
+	covered := 0
+	for _, ok := range entities {
+		if ok {
+			covered++
+		}
+	}
+
+	return float64(covered) / float64(len(entities)) * 100
+}
+
+// eventWeight returns the weight of an Event, the numeric value of its Check or
+// Entity weight label, or 1 if no weight label is set or found.
+func eventWeight(event *types.Event) int {
+	if weightLabel == "" {
+		return 1
+	}
+
+	value, ok := event.Check.ObjectMeta.Labels[weightLabel]
+	if !ok {
+		value, ok = event.Entity.ObjectMeta.Labels[weightLabel]
+	}
+	if !ok {
+		return 1
+	}
+
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight < 0 {
+		return 1
+	}
+
+	return weight
+}
+
+// isOk returns whether a Check status is OK, either 0 or one of --ok-statuses.
+func isOk(status uint32) bool {
+	return status == 0 || okStatuses[status]
+}
+
+// eventStatus returns the status an Event is counted as, resolving the status
+// of flapping Events with --resolve-flapping and escalating warnings with
+// --warn-escalate-after.
+func eventStatus(event *types.Event) uint32 {
+	status := flappingStatus(event)
+
+	if status == 1 && escalateAfter != 0 && event.Check.LastOK != 0 &&
+		time.Since(time.Unix(event.Check.LastOK, 0)) >= escalateAfter {
+		return 2
+	}
+
+	return status
+}
+
+// flappingStatus returns the status of an Event, resolving the status of
+// flapping Events with --resolve-flapping.
+func flappingStatus(event *types.Event) uint32 {
+	if resolveFlapping == "" || event.Check.State != "flapping" {
+		return event.Check.Status
+	}
+
+	history := event.Check.History
+
+	switch resolveFlapping {
+	case "unknown":
+		return 3
+	case "worst":
+		worst := event.Check.Status
+		for _, entry := range history {
+			if entry.Status > worst {
+				worst = entry.Status
+			}
+		}
+		return worst
+	default:
+		// The most recent status that was returned by consecutive executions.
+		for i := len(history) - 1; i > 0; i-- {
+			if history[i].Status == history[i-1].Status {
+				return history[i].Status
+			}
+		}
+		return event.Check.Status
+	}
+}
+
+// eventOk returns whether an Event counts as OK, by its Check status or the
+// --classify-expr. The classification of an Event by the expression is cached,
+// and the first error is kept to fail the evaluation.
+func eventOk(event *types.Event) bool {
+	if classifyScript == nil {
+		return isOk(eventStatus(event))
+	}
+
+	if ok, cached := classified[event]; cached {
+		return ok
+	}
+
+	ok, err := classify(event)
+	if err != nil && classifyErr == nil {
+		classifyErr = fmt.Errorf("failed to classify %s/%s: %v", event.Entity.ObjectMeta.Name, event.Check.ObjectMeta.Name, err)
+	}
+
+	classified[event] = ok
+
+	return ok
+}
+
+// classify evaluates the --classify-expr for an Event.
+func classify(event *types.Event) (bool, error) {
+	labels := map[string]string{}
+	for key, value := range event.Entity.ObjectMeta.Labels {
+		labels[key] = value
+	}
+	for key, value := range event.Check.ObjectMeta.Labels {
+		labels[key] = value
+	}
+
+	for name, value := range map[string]interface{}{
+		"status":    eventStatus(event),
+		"labels":    labels,
+		"entity":    event.Entity.ObjectMeta.Name,
+		"check":     event.Check.ObjectMeta.Name,
+		"namespace": eventNamespace(event),
+		"age":       time.Since(time.Unix(event.Timestamp, 0)).Seconds(),
+	} {
+		if err := classifyVm.Set(name, value); err != nil {
+			return false, err
+		}
+	}
+
+	value, err := classifyVm.Run(classifyScript)
+	if err != nil {
+		return false, err
+	}
+
+	return value.ToBoolean()
+}
+
+// recentlyRecovered returns whether an Event is OK but has a non-OK entry in its
+// Check History executed within the window.
+func recentlyRecovered(event *types.Event, window time.Duration) bool {
+	if !eventOk(event) {
+		return false
+	}
+
+	since := time.Now().Add(-window).Unix()
+
+	for _, history := range event.Check.History {
+		if history.Executed >= since && !isOk(history.Status) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unresolvedEvents returns the critical Events whose Check was last OK longer
+// than the duration ago, the longest unresolved Event first.
+func unresolvedEvents(events []*types.Event, after time.Duration) []*types.Event {
+	unresolved := []*types.Event{}
+
+	since := time.Now().Add(-after).Unix()
+
+	for _, event := range events {
+		if !eventCritical(event) || event.Check.LastOK == 0 {
+			continue
+		}
+
+		if event.Check.LastOK <= since {
+			unresolved = append(unresolved, event)
+		}
+	}
+
+	sort.SliceStable(unresolved, func(i, j int) bool {
+		return unresolved[i].Check.LastOK < unresolved[j].Check.LastOK
+	})
+
+	return unresolved
+}
+
+// eventCritical returns whether an Event is counted as critical.
+func eventCritical(event *types.Event) bool {
+	return !eventOk(event) && eventStatus(event) == 2
+}
+
+func countEvents(events []*types.Event) Counters {
+	counters := Counters{}
+
+	entities := map[string]string{}
+	checks := map[string]string{}
+
+	for _, event := range events {
+		entities[event.Entity.ObjectMeta.Name] = ""
+		checks[event.Check.ObjectMeta.Name] = ""
+
+		weight := eventWeight(event)
+		status := eventStatus(event)
+
+		switch {
+		case eventOk(event):
+			counters.Ok += weight
+		case status <= 1:
+			counters.Warning += weight
+		case status == 2:
+			counters.Critical += weight
+		default:
+			counters.Unknown += weight
+		}
+
+		counters.Total += weight
+
+		if recoveredWindow != 0 && recentlyRecovered(event, recoveredWindow) {
+			counters.Recovered += weight
+		}
+	}
+
+	counters.Entities = len(entities)
+	counters.Checks = len(checks)
+
+	return counters
+}
+
+func roundValue(value float64) float64 {
+	switch rounding {
+	case "ceil":
+		return math.Ceil(value)
+	case "nearest":
+		return math.Round(value)
+	default:
+		return math.Floor(value)
+	}
+}
+
+func roundPercent(percent float64) int {
+	return int(roundValue(percent))
+}
+
+// formatPercent formats a percentage with --precision decimals, rounded
+// according to --round.
+func formatPercent(percent float64) string {
+	scale := math.Pow(10, float64(precision))
+
+	return strconv.FormatFloat(roundValue(percent*scale)/scale, 'f', precision, 64)
+}
+
+func formatCounters(counters Counters) string {
+	if !countRatios {
+		return fmt.Sprintf("%+v", counters)
+	}
+
+	return fmt.Sprintf("{Entities:%d Checks:%d Ok:%d/%d Warning:%d/%d Critical:%d/%d Unknown:%d/%d Total:%d Recovered:%d/%d Invalid:%d NoOutput:%d}",
+		counters.Entities,
+		counters.Checks,
+		counters.Ok, counters.Total,
+		counters.Warning, counters.Total,
+		counters.Critical, counters.Total,
+		counters.Unknown, counters.Total,
+		counters.Total,
+		counters.Recovered, counters.Total,
+		counters.Invalid,
+		counters.NoOutput)
+}
+
+func parseDistribution(arg string) (map[string]float64, error) {
+	distribution := map[string]float64{}
+
+	if strings.TrimSpace(arg) == "" {
+		return distribution, nil
+	}
+
+	for _, pair := range strings.Split(arg, ",") {
+		parts := strings.Split(strings.TrimSpace(pair), "=")
+		if len(parts) != 2 {
+			return distribution, fmt.Errorf("invalid distribution %q, expected 'status=percent'", pair)
+		}
+
+		switch parts[0] {
+		case "ok", "warning", "critical", "unknown":
+		default:
+			return distribution, fmt.Errorf("invalid distribution status %q, expected one of 'ok', 'warning', 'critical' or 'unknown'", parts[0])
+		}
+
+		percent, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return distribution, fmt.Errorf("invalid distribution percent %q", parts[1])
+		}
+
+		distribution[parts[0]] = percent
+	}
+
+	return distribution, nil
+}
+
+// thresholdKinds are the thresholds that can be set with --thresholds, as
+// '<warn|crit>-<kind>' flags.
+var thresholdKinds = []string{"percent", "count", "stale", "recovered", "invalid", "no-output", "unresolved", "mtslok", "trend", "score", "reliability", "metric"}
+
+// parseThresholds parses --thresholds, e.g. 'warn:count=5,crit:percent=90',
+// into the values of the corresponding threshold flags.
+func parseThresholds(arg string) (map[string]string, error) {
+	values := map[string]string{}
+
+	for _, pair := range strings.Split(arg, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		keys := strings.SplitN(parts[0], ":", 2)
+		if len(parts) != 2 || len(keys) != 2 {
+			return values, fmt.Errorf("invalid threshold %q, expected 'severity:kind=value'", pair)
+		}
+
+		if keys[0] != "warn" && keys[0] != "crit" {
+			return values, fmt.Errorf("invalid threshold severity %q, expected 'warn' or 'crit'", keys[0])
+		}
+
+		known := false
+		for _, kind := range thresholdKinds {
+			known = known || kind == keys[1]
+		}
+		if !known {
+			return values, fmt.Errorf("invalid threshold kind %q, expected one of '%s'", keys[1], strings.Join(thresholdKinds, "', '"))
+		}
+
+		values[keys[0]+"-"+keys[1]] = parts[1]
+	}
+
+	return values, nil
+}
+
+func parseWeights(arg string) (map[string]float64, error) {
+	weights := map[string]float64{}
+
+	if strings.TrimSpace(arg) == "" {
+		return weights, nil
+	}
+
+	for _, pair := range strings.Split(arg, ",") {
+		parts := strings.Split(strings.TrimSpace(pair), "=")
+		if len(parts) != 2 {
+			return weights, fmt.Errorf("invalid severity weight %q, expected 'status=weight'", pair)
+		}
+
+		switch parts[0] {
+		case "warning", "critical", "unknown":
+		default:
+			return weights, fmt.Errorf("invalid severity weight status %q, expected one of 'warning', 'critical' or 'unknown'", parts[0])
+		}
+
+		weight, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || weight < 0 {
+			return weights, fmt.Errorf("invalid severity weight %q", parts[1])
+		}
+
+		weights[parts[0]] = weight
+	}
+
+	return weights, nil
+}
+
+// severityScore returns the health score of the counters, 100 minus the
+// weighted percentage of Events in a warning, critical or unknown state.
+func severityScore(counters Counters, weights map[string]float64) float64 {
+	if counters.Total == 0 {
+		return 100
+	}
+
+	weighted := float64(counters.Warning)*weights["warning"] +
+		float64(counters.Critical)*weights["critical"] +
+		float64(counters.Unknown)*weights["unknown"]
+
+	return 100 - weighted/float64(counters.Total)*100
+}
+
+// skewedStatuses returns the statuses whose actual percentage deviates more
+// than the tolerance from the expected distribution.
+func skewedStatuses(counters Counters, expected map[string]float64, tolerance float64) []string {
+	actual := map[string]float64{
+		"ok":       float64(counters.Ok) / float64(counters.Total) * 100,
+		"warning":  float64(counters.Warning) / float64(counters.Total) * 100,
+		"critical": float64(counters.Critical) / float64(counters.Total) * 100,
+		"unknown":  float64(counters.Unknown) / float64(counters.Total) * 100,
+	}
+
+	result := []string{}
+
+	for _, status := range []string{"ok", "warning", "critical", "unknown"} {
+		percent, ok := expected[status]
+		if !ok {
+			continue
+		}
+
+		if math.Abs(actual[status]-percent) > tolerance {
+			result = append(result, fmt.Sprintf("%s %.f%% instead of %.f%%", status, actual[status], percent))
+		}
+	}
+
+	return result
+}
+
+// emptyNamespaces returns the queried namespaces without matched Events, of
+// the --namespaces with an --events-file.
+func emptyNamespaces(events []*types.Event) []string {
+	counted := countNamespaces(events)
+	result := []string{}
+
+	groups := queriedNamespaces
+	if eventsFile != "" {
+		groups = strings.Split(namespaces, ",")
+	}
+
+	for _, group := range groups {
+		if _, ok := counted[group]; !ok {
+			result = append(result, group)
+		}
+	}
+
+	return result
+}
+
+// unexpectedNamespaces returns the Namespaces of the Events that are not one of
+// the --expected-namespaces.
+func unexpectedNamespaces(events []*types.Event) []string {
+	expected := parseList(expectedNamespaces)
+	unexpected := map[string]bool{}
+
+	for _, event := range events {
+		if namespace := namespaceOf(event); !expected[namespace] {
+			unexpected[namespace] = true
+		}
+	}
+
+	result := []string{}
+	for namespace := range unexpected {
+		result = append(result, namespace)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// driftedChecks returns the Checks that run more than one distinct command.
+func driftedChecks(events []*types.Event) []string {
+	commands := map[string]map[string]bool{}
+
+	for _, event := range events {
+		name := event.Check.ObjectMeta.Name
+		if commands[name] == nil {
+			commands[name] = map[string]bool{}
+		}
+		commands[name][event.Check.Command] = true
+	}
+
+	result := []string{}
+
+	for name, distinct := range commands {
+		if len(distinct) > 1 {
+			result = append(result, fmt.Sprintf("%s (%d commands)", name, len(distinct)))
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// aggregateMetric returns the aggregate of the values of the named metric point
+// across the Events, and the number of Events carrying it.
+func aggregateMetric(events []*types.Event, name string, aggregate string) (float64, int) {
+	var result float64
+	count := 0
+
+	for _, event := range events {
+		if event.Metrics == nil {
+			continue
+		}
+
+		for _, point := range event.Metrics.Points {
+			if point == nil || point.Name != name {
+				continue
+			}
+
+			switch {
+			case count == 0:
+				result = point.Value
+			case aggregate == "max":
+				result = math.Max(result, point.Value)
+			default:
+				result += point.Value
+			}
+
+			count++
+			break
+		}
+	}
+
+	if aggregate == "avg" && count > 0 {
+		result /= float64(count)
+	}
+
+	return result, count
+}
+
+// meanSinceLastOk returns the mean time since the Check was last OK of the
+// critical Events, and the number of these Events. Events whose Check was never
+// OK are skipped.
+func meanSinceLastOk(events []*types.Event) (time.Duration, int) {
+	var total int64
+	count := 0
+
+	now := time.Now().Unix()
+
+	for _, event := range events {
+		if !eventCritical(event) || event.Check.LastOK == 0 {
+			continue
+		}
+
+		total += now - event.Check.LastOK
+		count++
+	}
+
+	if count == 0 {
+		return 0, 0
+	}
+
+	return time.Duration(total/int64(count)) * time.Second, count
+}
+
+// reliability returns the average percentage of OK executions in the Check
+// History of the Events. Events without History count by their current status.
+func reliability(events []*types.Event) float64 {
+	if len(events) == 0 {
+		return 100
+	}
+
+	var total float64
+
+	for _, event := range events {
+		if len(event.Check.History) == 0 {
+			if isOk(event.Check.Status) {
+				total++
+			}
+			continue
+		}
+
+		ok := 0
+		for _, history := range event.Check.History {
+			if isOk(history.Status) {
+				ok++
+			}
+		}
+
+		total += float64(ok) / float64(len(event.Check.History))
+	}
+
+	return total / float64(len(events)) * 100
+}
+
+func evalThresholds(counters Counters, percent int, events []*types.Event) []Threshold {
+	thresholds := []Threshold{}
+
+	// Critical subscriptions and partial outages are critical regardless of
+	// the other thresholds, so they are evaluated first.
+	if criticalSubscriptions != "" {
+		failing := failingCritical(events, parseList(criticalSubscriptions))
+
+		thresholds = append(thresholds, Threshold{
+			Status:  2,
+			Tripped: len(failing) > 0,
+			Message: fmt.Sprintf("%d Events of Entities with a critical subscription are not OK (%s)", len(failing), strings.Join(failing, ", ")),
+		})
+	}
+
+	if detectPartialOutage {
+		partial := partialOutages(events)
+
+		thresholds = append(thresholds, Threshold{
+			Status:  2,
+			Tripped: len(partial) > 0,
+			Message: fmt.Sprintf("%d Checks are OK on some Entities but critical on others (%s)", len(partial), strings.Join(partial, ", ")),
+		})
+	}
+
+	if critPercent != 0 {
+		thresholds = append(thresholds, Threshold{
+			Status:  2,
+			Tripped: percent <= critPercent,
+			Message: fmt.Sprintf("Less than %d%% percent OK (%d%%)", critPercent, percent),
+		})
+	}
+
+	if warnPercent != 0 {
+		thresholds = append(thresholds, Threshold{
+			Status:  1,
+			Tripped: percent <= warnPercent,
+			Message: fmt.Sprintf("Less than %d%% percent OK (%d%%)", warnPercent, percent),
+		})
+	}
+
+	if critCount != 0 {
+		thresholds = append(thresholds, Threshold{
+			Status:  2,
+			Tripped: counters.Critical >= critCount,
+			Message: fmt.Sprintf("%d or more Events are in a Critical state (%d)", critCount, counters.Critical),
+		})
+	}
+
+	if warnCount != 0 {
+		thresholds = append(thresholds, Threshold{
+			Status:  1,
+			Tripped: counters.Warning >= warnCount,
+			Message: fmt.Sprintf("%d or more Events are in a Warning state (%d)", warnCount, counters.Warning),
+			Exit:    2,
+		})
+	}
+
+	if critStale != 0 || warnStale != 0 {
+		oldest := oldestEvent(events)
+		age := time.Since(time.Unix(oldest.Timestamp, 0)).Round(time.Second)
+
+		if critStale != 0 {
+			thresholds = append(thresholds, Threshold{
+				Status:  2,
+				Tripped: age >= critStale,
+				Message: fmt.Sprintf("Oldest Event is older than %s (%s/%s, %s)", critStale, oldest.Entity.ObjectMeta.Name, oldest.Check.ObjectMeta.Name, age),
+			})
+		}
+
+		if warnStale != 0 {
+			thresholds = append(thresholds, Threshold{
+				Status:  1,
+				Tripped: age >= warnStale,
+				Message: fmt.Sprintf("Oldest Event is older than %s (%s/%s, %s)", warnStale, oldest.Entity.ObjectMeta.Name, oldest.Check.ObjectMeta.Name, age),
+			})
+		}
+	}
+
+	if critRecovered != 0 {
+		thresholds = append(thresholds, Threshold{
+			Status:  2,
+			Tripped: counters.Recovered >= critRecovered,
+			Message: fmt.Sprintf("%d or more Events recovered within %s (%d)", critRecovered, recoveredWindow, counters.Recovered),
+		})
+	}
+
+	if warnRecovered != 0 {
+		thresholds = append(thresholds, Threshold{
+			Status:  1,
+			Tripped: counters.Recovered >= warnRecovered,
+			Message: fmt.Sprintf("%d or more Events recovered within %s (%d)", warnRecovered, recoveredWindow, counters.Recovered),
+		})
+	}
+
+	if critUnresolved != 0 || warnUnresolved != 0 {
+		unresolved := unresolvedEvents(events, unresolvedAfter)
+
+		message := fmt.Sprintf("Events are critical for longer than %s (%d)", unresolvedAfter, len(unresolved))
+		if len(unresolved) > 0 {
+			longest := unresolved[0]
+			message += fmt.Sprintf(", longest %s/%s for %s", longest.Entity.ObjectMeta.Name, longest.Check.ObjectMeta.Name, time.Since(time.Unix(longest.Check.LastOK, 0)).Round(time.Second))
+		}
+
+		if critUnresolved != 0 {
+			thresholds = append(thresholds, Threshold{
+				Status:  2,
+				Tripped: len(unresolved) >= critUnresolved,
+				Message: fmt.Sprintf("%d or more %s", critUnresolved, message),
+			})
+		}
+
+		if warnUnresolved != 0 {
+			thresholds = append(thresholds, Threshold{
+				Status:  1,
+				Tripped: len(unresolved) >= warnUnresolved,
+				Message: fmt.Sprintf("%d or more %s", warnUnresolved, message),
+			})
+		}
+	}
+
+	if critInvalid != 0 {
+		thresholds = append(thresholds, Threshold{
+			Status:  2,
+			Tripped: counters.Invalid >= critInvalid,
+			Message: fmt.Sprintf("%d or more Events without Check or Entity were returned (%d)", critInvalid, counters.Invalid),
+		})
+	}
+
+	if warnInvalid != 0 {
+		thresholds = append(thresholds, Threshold{
+			Status:  1,
+			Tripped: counters.Invalid >= warnInvalid,
+			Message: fmt.Sprintf("%d or more Events without Check or Entity were returned (%d)", warnInvalid, counters.Invalid),
+		})
+	}
+
+	if critNoOutput != 0 {
+		thresholds = append(thresholds, Threshold{
+			Status:  2,
+			Tripped: counters.NoOutput >= critNoOutput,
+			Message: fmt.Sprintf("%d or more Events without Check output were returned (%d)", critNoOutput, counters.NoOutput),
+		})
+	}
+
+	if warnNoOutput != 0 {
+		thresholds = append(thresholds, Threshold{
+			Status:  1,
+			Tripped: counters.NoOutput >= warnNoOutput,
+			Message: fmt.Sprintf("%d or more Events without Check output were returned (%d)", warnNoOutput, counters.NoOutput),
+		})
+	}
+
+	if critEmptyNamespace || warnEmptyNamespace {
+		empty := emptyNamespaces(events)
+		status := 1
+		if critEmptyNamespace {
+			status = 2
+		}
+
+		thresholds = append(thresholds, Threshold{
+			Status:  status,
+			Tripped: len(empty) > 0,
+			Message: fmt.Sprintf("%d Namespaces have no matching Events (%s)", len(empty), strings.Join(empty, ", ")),
+		})
+	}
+
+	if expectedNamespaces != "" {
+		unexpected := unexpectedNamespaces(events)
+		status := 1
+		if critUnexpected {
+			status = 2
+		}
+
+		thresholds = append(thresholds, Threshold{
+			Status:  status,
+			Tripped: len(unexpected) > 0,
+			Message: fmt.Sprintf("%d unexpected Namespaces have matching Events (%s)", len(unexpected), strings.Join(unexpected, ", ")),
+		})
+	}
+
+	if len(distribution) > 0 {
+		skewed := skewedStatuses(counters, distribution, distTolerance)
+
+		thresholds = append(thresholds, Threshold{
+			Status:  1,
+			Tripped: len(skewed) > 0,
+			Message: fmt.Sprintf("Status distribution deviates more than %.f%% from the expected distribution (%s)", distTolerance, strings.Join(skewed, ", ")),
+		})
+	}
+
+	if warnCommandDrift {
+		drifted := driftedChecks(events)
+
+		thresholds = append(thresholds, Threshold{
+			Status:  1,
+			Tripped: len(drifted) > 0,
+			Message: fmt.Sprintf("%d Checks have diverging commands across Entities (%s)", len(drifted), strings.Join(drifted, ", ")),
+		})
+	}
+
+	if critScore != 0 || warnScore != 0 {
+		score := severityScore(counters, severityWeights)
+
+		if critScore != 0 {
+			thresholds = append(thresholds, Threshold{
+				Status:  2,
+				Tripped: score <= float64(critScore),
+				Message: fmt.Sprintf("Severity score is %d or less (%s)", critScore, formatPercent(score)),
+			})
+		}
+
+		if warnScore != 0 {
+			thresholds = append(thresholds, Threshold{
+				Status:  1,
+				Tripped: score <= float64(warnScore),
+				Message: fmt.Sprintf("Severity score is %d or less (%s)", warnScore, formatPercent(score)),
+			})
+		}
+	}
+
+	if critReliability != 0 || warnReliability != 0 {
+		value := reliability(events)
+
+		if critReliability != 0 {
+			thresholds = append(thresholds, Threshold{
+				Status:  2,
+				Tripped: value <= float64(critReliability),
+				Message: fmt.Sprintf("Reliability of the Check History is %d%% or less (%s%%)", critReliability, formatPercent(value)),
+			})
+		}
+
+		if warnReliability != 0 {
+			thresholds = append(thresholds, Threshold{
+				Status:  1,
+				Tripped: value <= float64(warnReliability),
+				Message: fmt.Sprintf("Reliability of the Check History is %d%% or less (%s%%)", warnReliability, formatPercent(value)),
+			})
+		}
+	}
+
+	if critMtslok != 0 || warnMtslok != 0 {
+		value, count := meanSinceLastOk(events)
+
+		if critMtslok != 0 {
+			thresholds = append(thresholds, Threshold{
+				Status:  2,
+				Tripped: count > 0 && value >= critMtslok,
+				Message: fmt.Sprintf("Mean time since last OK of critical Events is %s or more (%s over %d Events)", critMtslok, value, count),
+			})
+		}
+
+		if warnMtslok != 0 {
+			thresholds = append(thresholds, Threshold{
+				Status:  1,
+				Tripped: count > 0 && value >= warnMtslok,
+				Message: fmt.Sprintf("Mean time since last OK of critical Events is %s or more (%s over %d Events)", warnMtslok, value, count),
+			})
+		}
+	}
+
+	if critMetric != 0 || warnMetric != 0 {
+		value, count := aggregateMetric(events, metricName, metricAggregate)
+
+		if critMetric != 0 {
+			thresholds = append(thresholds, Threshold{
+				Status:  2,
+				Tripped: count > 0 && value >= critMetric,
+				Message: fmt.Sprintf("%s of %s is %g or more (%g over %d Events)", metricAggregate, metricName, critMetric, value, count),
+			})
+		}
+
+		if warnMetric != 0 {
+			thresholds = append(thresholds, Threshold{
+				Status:  1,
+				Tripped: count > 0 && value >= warnMetric,
+				Message: fmt.Sprintf("%s of %s is %g or more (%g over %d Events)", metricAggregate, metricName, warnMetric, value, count),
+			})
+		}
+	}
+
+	if maxEntityIdle != 0 && entityIdleAction == "flag" {
+		idle := idleEntities(events, maxEntityIdle)
+
+		thresholds = append(thresholds, Threshold{
+			Status:  1,
+			Tripped: len(idle) > 0,
+			Message: fmt.Sprintf("%d Entities were not seen for longer than %s (%s)", len(idle), maxEntityIdle, strings.Join(idle, ", ")),
+		})
+	}
+
+	if stalenessFactor != 0 {
+		overdue := overdueEvents(events, stalenessFactor)
+
+		thresholds = append(thresholds, Threshold{
+			Status:  1,
+			Tripped: len(overdue) > 0,
+			Message: fmt.Sprintf("%d Events are older than %g times their Check interval (%s)", len(overdue), stalenessFactor, strings.Join(overdue, ", ")),
+		})
+	}
+
+	if minChecks != 0 {
+		deficient := deficientEntities(events, minChecks)
+
+		thresholds = append(thresholds, Threshold{
+			Status:  1,
+			Tripped: len(deficient) > 0,
+			Message: fmt.Sprintf("%d Entities report less than %d checks (%s)", len(deficient), minChecks, strings.Join(deficient, ", ")),
+		})
+	}
+
+	checks := []string{}
+	for check := range coverage {
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+
+	for _, check := range checks {
+		value := checkCoverage(events, check)
+
+		thresholds = append(thresholds, Threshold{
+			Status:  1,
+			Tripped: value < coverage[check],
+			Message: fmt.Sprintf("Check %s runs on less than %g%% of the Entities (%s%%)", check, coverage[check], formatPercent(value)),
+		})
+	}
+
+	return thresholds
+}
+
+// selectThreshold returns the status and output of the tripped thresholds.
+// With the 'any' logic the first tripped threshold wins, with the 'all' logic
+// a severity only applies when every threshold set for it tripped. A threshold
+// with an Exit status exits with it instead of its severity, i.e. --warn-count
+// exits with 2 as it always did.
+func selectThreshold(thresholds []Threshold) (int, string) {
+	if thresholdLogic == "any" {
+		for _, threshold := range thresholds {
+			if threshold.Tripped {
+				return exitStatus(threshold), fmt.Sprintf("%s: %s", statusNames[threshold.Status], threshold.Message)
+			}
+		}
+
+		return 0, ""
+	}
+
+	for _, status := range []int{2, 1} {
+		messages := []string{}
+		tripped := true
+		exit := status
+
+		for _, threshold := range thresholds {
+			if threshold.Status == status {
+				messages = append(messages, threshold.Message)
+				tripped = tripped && threshold.Tripped
+				if exitStatus(threshold) > exit {
+					exit = exitStatus(threshold)
+				}
+			}
+		}
+
+		if len(messages) > 0 && tripped {
+			return exit, fmt.Sprintf("%s: %s", statusNames[status], strings.Join(messages, ", "))
+		}
+	}
+
+	return 0, ""
+}
+
+// exitStatus returns the exit status of a tripped threshold.
+func exitStatus(threshold Threshold) int {
+	if threshold.Exit != 0 {
+		return threshold.Exit
+	}
+
+	return threshold.Status
+}
+
+// unknownEvents returns the Events counted as Unknown, classified like the
+// counters.
+func unknownEvents(events []*types.Event) []string {
+	result := []string{}
+
+	for _, event := range events {
+		if !eventOk(event) && eventStatus(event) > 2 {
+			result = append(result, fmt.Sprintf("%s/%s", event.Entity.ObjectMeta.Name, event.Check.ObjectMeta.Name))
+		}
+	}
+
+	return result
+}
+
+// eventNamespace returns the Namespace of an Event, or the value of its Entity
+// namespace label when --namespace-label is set and present.
+func eventNamespace(event *types.Event) string {
+	if namespaceLabel != "" {
+		if value, ok := event.Entity.ObjectMeta.Labels[namespaceLabel]; ok {
+			return value
+		}
+	}
+
+	return namespaceOf(event)
+}
+
+// namespaceOf returns the Namespace of an Event, or of its Entity.
+func namespaceOf(event *types.Event) string {
+	if event.ObjectMeta.Namespace != "" {
+		return event.ObjectMeta.Namespace
+	}
+
+	return event.Entity.ObjectMeta.Namespace
+}
+
+// eventGroup returns the group an Event is counted in by Namespace, its
+// eventNamespace prefixed with the backend it was retrieved from when
+// aggregating multiple backends.
+func eventGroup(event *types.Event) string {
+	if backend, ok := event.ObjectMeta.Annotations[backendAnnotation]; ok {
+		return fmt.Sprintf("%s/%s", backend, eventNamespace(event))
+	}
+
+	return eventNamespace(event)
+}
+
+func countNamespaces(events []*types.Event) map[string]Counters {
+	grouped := map[string][]*types.Event{}
+
+	for _, event := range events {
+		group := eventGroup(event)
+		grouped[group] = append(grouped[group], event)
+	}
+
+	result := map[string]Counters{}
+
+	for namespace, selected := range grouped {
+		result[namespace] = countEvents(selected)
+	}
+
+	return result
+}
+
+// percentBase returns the number of Events the percentage OK is computed over,
+// by the --percent-base.
+func percentBase(counters Counters) int {
+	switch percentBaseArg {
+	case "total":
+		return counters.Total
+	case "known":
+		if counters.Total == counters.Unknown {
+			return counters.Total
+		}
+		return counters.Total - counters.Unknown
+	}
+
+	if expectedTotal > counters.Total {
+		return expectedTotal
+	}
+
+	return counters.Total
+}
+
+func evalEvents(events []*types.Event) Result {
+	result := Result{
+		Counters:   countEvents(events),
+		Namespaces: countNamespaces(events),
+		Events:     events,
+	}
+
+	result.Counters.Invalid = invalidEvents
+	result.Counters.NoOutput = emptyOutputEvents
+
+	counters := result.Counters
+
+	if counters.Total == 0 {
+		result.Status = 1
+		result.Output = "WARNING: No Events returned for Aggregate"
+		return result
+	}
+
+	result.RawPercent = float64(counters.Ok) / float64(percentBase(counters)) * 100
+
+	percent := roundPercent(result.RawPercent)
+
+	result.Percent = percent
+
+	if critMtslok != 0 || warnMtslok != 0 {
+		mean, _ := meanSinceLastOk(events)
+		result.MeanSinceLastOk = int64(mean.Seconds())
+	}
+
+	if anyUnknown && counters.Unknown > 0 {
+		result.Status = 3
+		result.Output = fmt.Sprintf("UNKNOWN: %d Events are in an Unknown state (%s)", counters.Unknown, strings.Join(unknownEvents(events), ", "))
+		return result
+	}
+
+	thresholds := evalThresholds(counters, percent, events)
+
+	if status, output := selectThreshold(thresholds); status != 0 {
+		result.Status = status
+		result.Output = output
+		return result
+	}
+
+	output, err := renderTemplate(okTmpl, result)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to render the OK message: %v\n", err)
+		output = "Everything is OK"
+	}
+
+	result.Output = output
+
+	return result
+}
+
+func formatText(result Result) string {
+	text := ""
+
+	if statusPrefixOnly {
+		text += statusLine(result) + "\n"
+	}
+
+	text += fmt.Sprintf("Counters: %s\n", formatCounters(result.Counters))
+
+	if result.Counters.Total != 0 {
+		text += fmt.Sprintf("Percent OK: %s\n", formatPercent(result.RawPercent))
+	}
+
+	if byNamespace {
+		names := []string{}
+		for name := range result.Namespaces {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			text += fmt.Sprintf("Namespace %s: %s\n", name, formatCounters(result.Namespaces[name]))
+		}
+	}
+
+	if len(result.Failed) > 0 {
+		names := []string{}
+		for name := range result.Failed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			text += fmt.Sprintf("Failed Namespace %s: %s\n", name, result.Failed[name])
+		}
+	}
+
+	if result.Partial {
+		text += fmt.Sprintf("Partial result: the --deadline of %s was exceeded\n", deadline)
+	}
+
+	if result.MeanSinceLastOk != 0 {
+		text += fmt.Sprintf("Mean time since last OK: %s\n", time.Duration(result.MeanSinceLastOk)*time.Second)
+	}
+
+	if warnTrend != 0 || critTrend != 0 {
+		text += fmt.Sprintf("Trend: %g%% per run\n", result.Trend)
+	}
+
+	if histogram && result.Counters.Total != 0 {
+		text += formatHistogram(result.Counters)
+	}
+
+	if !statusPrefixOnly {
+		text += result.Output + "\n"
+	}
+
+	for _, notice := range result.Notices {
+		text += fmt.Sprintf("Notice: %s\n", notice)
+	}
+
+	if listHealthy {
+		text += fmt.Sprintf("Healthy Entities: %s\n", strings.Join(result.Healthy, ", "))
+	}
+
+	if traceFilter {
+		text += formatFilterTrace()
+	}
+
+	metrics := ""
+	if metricsFormat != "" {
+		metrics = formatMetrics(result)
+	}
+
+	if verbose {
+		text += truncateLines(formatOffending(result.Events), maxOutputBytes-len(text)-len(metrics))
+	}
+
+	return truncateLines(text+metrics, maxOutputBytes)
+}
+
+func formatHistogram(counters Counters) string {
+	text := ""
+
+	for _, bar := range []struct {
+		name  string
+		count int
+	}{
+		{"OK", counters.Ok},
+		{"WARNING", counters.Warning},
+		{"CRITICAL", counters.Critical},
+		{"UNKNOWN", counters.Unknown},
+	} {
+		ratio := float64(bar.count) / float64(counters.Total)
+		length := int(math.Round(ratio * histogramWidth))
+
+		text += fmt.Sprintf("%-8s %s%s %3.f%%\n", bar.name, strings.Repeat("█", length), strings.Repeat(" ", histogramWidth-length), ratio*100)
+	}
+
+	return text
+}
+
+// truncateLines cuts text at a line boundary to fit within the given number of
+// bytes, noting how many lines were omitted. Text is left as is when
+// --max-output-bytes is not set.
+func truncateLines(text string, size int) string {
+	if maxOutputBytes == 0 || len(text) <= size {
+		return text
+	}
+
+	lines := strings.SplitAfter(strings.TrimSuffix(text, "\n"), "\n")
+	result := ""
+
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\n") + "\n"
+		note := fmt.Sprintf("  (%d more omitted)\n", len(lines)-i)
+
+		if len(result)+len(line)+len(note) > size {
+			if len(result)+len(note) > size {
+				return result
+			}
+			return result + note
+		}
+
+		result += line
+	}
+
+	return result
+}
+
+func statusName(status uint32) string {
+	if int(status) < len(statusNames) {
+		return statusNames[status]
+	}
+
+	return statusNames[3]
+}
+
+// statusLine returns the status line of a result starting with the name of its
+// status as the first word, for --status-prefix-only.
+func statusLine(result Result) string {
+	name := statusNames[3]
+	if result.Status >= 0 && result.Status < len(statusNames) {
+		name = statusNames[result.Status]
+	}
+
+	output := strings.TrimSpace(result.Output)
+	output = strings.TrimSpace(strings.TrimPrefix(output, name+":"))
+
+	return strings.TrimSpace(fmt.Sprintf("%s %s", name, output))
+}
+
+// formatFilterTrace returns the number of Events filtered out by each predicate,
+// most rejections first.
+func formatFilterTrace() string {
+	reasons := []string{}
+	for reason := range filterTrace {
+		reasons = append(reasons, reason)
+	}
+
+	sort.Slice(reasons, func(i, j int) bool {
+		if filterTrace[reasons[i]] != filterTrace[reasons[j]] {
+			return filterTrace[reasons[i]] > filterTrace[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+
+	text := ""
+	for _, reason := range reasons {
+		text += fmt.Sprintf("Filtered: %d rejected by %s\n", filterTrace[reason], reason)
+	}
+
+	return text
+}
+
+func formatOffending(events []*types.Event) string {
+	text := ""
+	keys := []string{}
+
+	if showAnnotations != "" {
+		keys = strings.Split(showAnnotations, ",")
+	}
+
+	for _, event := range events {
+		if eventOk(event) {
+			continue
+		}
+
+		text += fmt.Sprintf("  %s: %s/%s/%s", statusName(eventStatus(event)), namespaceOf(event), event.Entity.ObjectMeta.Name, event.Check.ObjectMeta.Name)
+
+		for _, key := range keys {
+			if value, ok := event.Check.ObjectMeta.Annotations[key]; ok {
+				text += fmt.Sprintf(" %s=%s", key, value)
+			} else if value, ok := event.Entity.ObjectMeta.Annotations[key]; ok {
+				text += fmt.Sprintf(" %s=%s", key, value)
+			} else if value, ok := event.ObjectMeta.Annotations[key]; ok {
+				text += fmt.Sprintf(" %s=%s", key, value)
+			}
+		}
+
+		text += "\n"
+	}
+
+	return text
+}
+
+// metricPoints returns the counters of a result as metric points, or none for
+// an OK result with --metrics-on-failure-only.
+func metricPoints(result Result, now int64) []*types.MetricPoint {
+	points := []*types.MetricPoint{}
+
+	if metricsOnFailureOnly && result.Status == 0 {
+		return points
+	}
+
+	for name, value := range map[string]int{
+		"entities":   result.Counters.Entities,
+		"checks":     result.Counters.Checks,
+		"ok":         result.Counters.Ok,
+		"warning":    result.Counters.Warning,
+		"critical":   result.Counters.Critical,
+		"unknown":    result.Counters.Unknown,
+		"total":      result.Counters.Total,
+		"percent_ok": result.Percent,
+	} {
+		points = append(points, &types.MetricPoint{
+			Name:      fmt.Sprintf("%s.%s", eventCheckName, name),
+			Value:     float64(value),
+			Timestamp: now,
+		})
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Name < points[j].Name
+	})
+
+	return points
+}
+
+// promName returns a metric name valid in Prometheus.
+func promName(name string) string {
+	return strings.NewReplacer(".", "_", "-", "_").Replace(name)
+}
+
+// formatMetrics formats the metric points of a result in the --metrics-format.
+func formatMetrics(result Result) string {
+	now := time.Now().Unix()
+	text := ""
+
+	for _, point := range metricPoints(result, now) {
+		switch metricsFormat {
+		case "graphite":
+			text += fmt.Sprintf("%s %g %d\n", point.Name, point.Value, point.Timestamp)
+		case "influx":
+			text += fmt.Sprintf("%s value=%g %d\n", point.Name, point.Value, point.Timestamp*int64(time.Second))
+		case "prometheus":
+			text += fmt.Sprintf("%s %g %d\n", promName(point.Name), point.Value, point.Timestamp*1000)
+		}
+	}
+
+	return text
+}
+
+func formatSensuEvent(result Result) (string, error) {
+	now := time.Now().Unix()
+
+	points := metricPoints(result, now)
+
+	event := types.Event{
+		Timestamp: now,
+		Check: &types.Check{
+			ObjectMeta: types.ObjectMeta{
+				Name: eventCheckName,
+			},
+			Status:   uint32(result.Status),
+			Output:   formatText(result),
+			Executed: now,
+		},
+		Metrics: &types.Metrics{
+			Points: points,
+		},
+	}
+
+	return marshalOutput(event)
+}
+
+func validFormat(format string) bool {
+	return format == "text" || format == "json" || format == "sensu-event" || format == "csv"
+}
+
+// formatCSV formats the counters, percent and status as CSV, with a row per
+// Namespace with --by-namespace.
+func formatCSV(result Result) (string, error) {
+	var buffer bytes.Buffer
+
+	columns := []string{"entities", "checks", "ok", "warning", "critical", "unknown", "total", "recovered", "invalid", "no_output", "percent", "status"}
+
+	row := func(counters Counters, percent float64) []string {
+		return []string{
+			strconv.Itoa(counters.Entities),
+			strconv.Itoa(counters.Checks),
+			strconv.Itoa(counters.Ok),
+			strconv.Itoa(counters.Warning),
+			strconv.Itoa(counters.Critical),
+			strconv.Itoa(counters.Unknown),
+			strconv.Itoa(counters.Total),
+			strconv.Itoa(counters.Recovered),
+			strconv.Itoa(counters.Invalid),
+			strconv.Itoa(counters.NoOutput),
+			formatPercent(percent),
+			strconv.Itoa(result.Status),
+		}
+	}
+
+	writer := csv.NewWriter(&buffer)
+
+	if !byNamespace {
+		writer.Write(columns)
+		writer.Write(row(result.Counters, result.RawPercent))
+		writer.Flush()
+		return buffer.String(), writer.Error()
+	}
+
+	names := []string{}
+	for name := range result.Namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writer.Write(append([]string{"namespace"}, columns...))
+
+	for _, name := range names {
+		counters := result.Namespaces[name]
+
+		var percent float64
+		if counters.Total != 0 {
+			percent = float64(counters.Ok) / float64(counters.Total) * 100
+		}
+
+		writer.Write(append([]string{name}, row(counters, percent)...))
+	}
+
+	writer.Flush()
+
+	return buffer.String(), writer.Error()
+}
+
+// marshalOutput encodes JSON output, indented with --json-pretty and on a
+// single line otherwise.
+func marshalOutput(v interface{}) (string, error) {
+	var data []byte
+	var err error
+
+	if jsonPretty {
+		data, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return string(data) + "\n", nil
+}
+
+// EventSummary is a matched Event in the JSON output with
+// --json-include-events summary.
+type EventSummary struct {
+	Namespace string `json:"namespace"`
+	Entity    string `json:"entity"`
+	Check     string `json:"check"`
+	Status    uint32 `json:"status"`
+}
+
+// renameKeys renames the keys of decoded JSON objects with the --json-key-map,
+// except the names of Namespaces and the embedded Events.
+func renameKeys(value interface{}, names bool) interface{} {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+
+	renamed := map[string]interface{}{}
+
+	for key, child := range object {
+		name := key
+		if mapped, ok := jsonKeyMap[key]; ok && !names {
+			name = mapped
+		}
+
+		switch {
+		case names:
+			renamed[name] = renameKeys(child, false)
+		case key == "events":
+			renamed[name] = child
+		default:
+			renamed[name] = renameKeys(child, key == "namespaces" || key == "failed")
+		}
+	}
+
+	return renamed
 }
 
-func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+// mapKeys returns the value with the keys of the --json-key-map renamed.
+func mapKeys(value interface{}) (interface{}, error) {
+	if len(jsonKeyMap) == 0 {
+		return value, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded interface{}
+
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		return nil, err
 	}
+
+	return renameKeys(decoded, false), nil
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "sensu-aggregate-check",
-		Short: "The Sensu Go Event Aggregates Check plugin",
-		RunE:  run,
+func formatJSON(result Result) (string, error) {
+	if jsonIncludeEvents == "none" {
+		value, err := mapKeys(result)
+		if err != nil {
+			return "", err
+		}
+
+		return marshalOutput(value)
 	}
 
-	cmd.Flags().StringVarP(&checkLabels,
-		"check-labels",
-		"l",
-		"",
-		"Sensu Go Event Check Labels to filter by (e.g. 'aggregate=foo')")
+	events := result.Events
+	if jsonEventsLimit > 0 && len(events) > jsonEventsLimit {
+		events = events[:jsonEventsLimit]
+	}
 
-	cmd.Flags().StringVarP(&entityLabels,
-		"entity-labels",
-		"e",
-		"",
-		"Sensu Go Event Entity Labels to filter by (e.g. 'aggregate=foo,app=bar')")
+	var embedded interface{} = events
 
-	cmd.Flags().StringVarP(&namespaces,
-		"namespaces",
-		"n",
-		"default",
-		"Comma-delimited list of Sensu Go Namespaces to query for Events (e.g. 'us-east-1,us-west-2')")
+	if jsonIncludeEvents == "summary" {
+		summaries := []EventSummary{}
+		for _, event := range events {
+			summaries = append(summaries, EventSummary{
+				Namespace: namespaceOf(event),
+				Entity:    event.Entity.ObjectMeta.Name,
+				Check:     event.Check.ObjectMeta.Name,
+				Status:    eventStatus(event),
+			})
+		}
+		embedded = summaries
+	}
 
-    cmd.Flags().StringVarP(&apiProto,
-        "api-proto",
-        "",
-        "http",
-        "Sensu Go Backend API Protocol (e.g. 'https')")
+	value, err := mapKeys(struct {
+		Result
+		Events interface{} `json:"events"`
+	}{result, embedded})
+	if err != nil {
+		return "", err
+	}
 
-	cmd.Flags().StringVarP(&apiHost,
-		"api-host",
-		"H",
-		"127.0.0.1",
-		"Sensu Go Backend API Host (e.g. 'sensu-backend.example.com')")
+	return marshalOutput(value)
+}
 
-	cmd.Flags().StringVarP(&apiPort,
-		"api-port",
-		"p",
-		"8080",
-		"Sensu Go Backend API Port (e.g. 4242)")
+func formatResult(result Result, format string) (string, error) {
+	switch format {
+	case "json":
+		return formatJSON(result)
+	case "sensu-event":
+		return formatSensuEvent(result)
+	case "csv":
+		return formatCSV(result)
+	default:
+		return formatText(result), nil
+	}
+}
 
-	cmd.Flags().StringVarP(&apiUser,
-		"api-user",
-		"u",
-		"admin",
-		"Sensu Go Backend API User")
+func writeResult(path string, result Result) error {
+	output, err := formatResult(result, fileFormat)
+	if err != nil {
+		return err
+	}
 
-	cmd.Flags().StringVarP(&apiPass,
-		"api-pass",
-		"P",
-		"P@ssw0rd!",
-		"Sensu Go Backend API User")
+	return ioutil.WriteFile(path, []byte(output), 0644)
+}
 
-    cmd.Flags().StringVarP(&caPath,
-        "ca-path",
-        "",
-        "",
-        "Path to CA certificate")
+func postResult(webhook string, result Result) error {
+	value, err := mapKeys(result)
+	if err != nil {
+		return err
+	}
 
-	cmd.Flags().IntVarP(&warnPercent,
-		"warn-percent",
-		"w",
-		0,
-		"Warning threshold - % of Events in warning state")
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
 
-	cmd.Flags().IntVarP(&critPercent,
-		"crit-percent",
-		"c",
-		0,
-		"Critical threshold - % of Events in critical state")
+	return postData(webhook, "application/json", data)
+}
 
-	cmd.Flags().IntVarP(&warnCount,
-		"warn-count",
-		"W",
-		0,
-		"Warning threshold - count of Events in warning state")
+// pushMetrics pushes the metrics of a result to a Prometheus Pushgateway,
+// grouped by the --event-check-name job and the selectors as instance.
+func pushMetrics(gateway string, result Result) error {
+	instance := checkLabels
+	if entityLabels != "" {
+		instance += "," + entityLabels
+	}
+	if instance == "" {
+		instance = "all"
+	}
 
-	cmd.Flags().IntVarP(&critCount,
-		"crit-count",
-		"C",
-		0,
-		"Critical threshold - count of Events in critical state")
+	pushUrl := fmt.Sprintf("%s/metrics/job/%s/instance@base64/%s",
+		strings.TrimSuffix(gateway, "/"),
+		url.PathEscape(eventCheckName),
+		base64.RawURLEncoding.EncodeToString([]byte(instance)))
 
-	_ = cmd.MarkFlagRequired("check-labels")
+	text := ""
+	for _, point := range metricPoints(result, time.Now().Unix()) {
+		name := promName(point.Name)
+		text += fmt.Sprintf("# TYPE %s gauge\n%s %g\n", name, name, point.Value)
+	}
 
-	return cmd
+	if text == "" {
+		return nil
+	}
+
+	return postData(pushUrl, "text/plain; version=0.0.4", []byte(text))
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+// postData POSTs the data with the --webhook-timeout, retrying
+// --webhook-retries times with a backoff.
+func postData(target string, contentType string, data []byte) error {
+	client := &http.Client{
+		Timeout: webhookTimeout,
 	}
 
-    if caPath != "" {
-        err := initCa(caPath)
-        if err != nil {
-            return err
-        }
-    }
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Post(target, contentType, bytes.NewReader(data))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
 
-	return evalAggregate()
+		if attempt >= webhookRetries {
+			return err
+		}
+
+		time.Sleep(retryDelay(attempt))
+	}
 }
 
-func initCa(caPath string) error {
-   certs := x509.NewCertPool()
-   pemData, err := ioutil.ReadFile(caPath)
-    if err != nil {
-       return err
-   }
-   certs.AppendCertsFromPEM(pemData)
+// retryDelay returns the delay before the retry of a failed attempt, counting
+// from 0.
+func retryDelay(attempt int) time.Duration {
+	delay := webhookBackoff
 
-   newTlsConfig := &tls.Config{}
-   newTlsConfig.RootCAs = certs
+	for i := 0; i < attempt && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
 
-   defaultTransport := http.DefaultTransport.(*http.Transport)
-   defaultTransport.TLSClientConfig = newTlsConfig
-   return nil
+	if delay > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+
+	return delay
 }
 
-func authenticate() (Auth, error) {
-	var auth Auth
-	req, err := http.NewRequest(
-		"GET",
-		fmt.Sprintf("%s://%s:%s/auth", apiProto, apiHost, apiPort),
-		nil,
-	)
+func stateKey() string {
+	return fmt.Sprintf("%s|%s|%s", checkLabels, entityLabels, namespaces)
+}
+
+func readState(path string) (map[string]*State, error) {
+	states := map[string]*State{}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return states, nil
+	}
 	if err != nil {
-		return auth, err
+		return states, err
 	}
 
-	req.SetBasicAuth(apiUser, apiPass)
+	err = json.Unmarshal(data, &states)
 
-	resp, err := http.DefaultClient.Do(req)
+	return states, err
+}
+
+func writeState(path string, states map[string]*State) error {
+	data, err := json.Marshal(states)
 	if err != nil {
-		return auth, err
+		return err
 	}
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return auth, err
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// renderTemplate renders a --message-template or --ok-message for a result.
+func renderTemplate(tmpl *template.Template, result Result) (string, error) {
+	var buf bytes.Buffer
+
+	status := "UNKNOWN"
+	if result.Status >= 0 && result.Status < len(statusNames) {
+		status = statusNames[result.Status]
 	}
 
-	err = json.NewDecoder(bytes.NewReader(body)).Decode(&auth)
+	err := tmpl.Execute(&buf, map[string]interface{}{
+		"Counters":     result.Counters,
+		"Namespaces":   result.Namespaces,
+		"Percent":      result.Percent,
+		"RawPercent":   result.RawPercent,
+		"Status":       result.Status,
+		"StatusName":   status,
+		"Output":       result.Output,
+		"CheckLabels":  checkLabels,
+		"EntityLabels": entityLabels,
+	})
 
-	return auth, err
+	return buf.String(), err
 }
 
-func parseLabelArg(labelArg string) map[string]string {
-	labels := map[string]string{}
-
-	pairs := strings.Split(labelArg, ",")
+// slope returns the least squares slope of the values per step.
+func slope(values []float64) float64 {
+	n := float64(len(values))
+	if n < 2 {
+		return 0
+	}
 
-	for _, pair := range pairs {
-		parts := strings.Split(pair, "=")
-		if len(parts) == 2 {
-			labels[parts[0]] = parts[1]
-		}
+	var sumX, sumY, sumXY, sumXX float64
+	for i, value := range values {
+		x := float64(i)
+		sumX += x
+		sumY += value
+		sumXY += x * value
+		sumXX += x * x
 	}
 
-	return labels
+	return (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
 }
 
-func filterEvents(events []*types.Event) []*types.Event {
-	result := []*types.Event{}
+// trendResult records the percentage OK of the last --trend-runs runs in the
+// state file, and raises the status of a result when it declines by
+// --warn-trend or --crit-trend percentage points per run or more.
+func trendResult(result Result) (Result, error) {
+	states, err := readState(stateFile)
+	if err != nil {
+		return result, err
+	}
 
-	cLabels := parseLabelArg(checkLabels)
-	eLabels := parseLabelArg(entityLabels)
+	key := stateKey()
+	state, ok := states[key]
+	if !ok {
+		state = &State{}
+		states[key] = state
+	}
 
-	for _, event := range events {
-		selected := true
+	if result.Counters.Total != 0 {
+		state.Percents = append(state.Percents, result.RawPercent)
+	}
+	if len(state.Percents) > trendRuns {
+		state.Percents = state.Percents[len(state.Percents)-trendRuns:]
+	}
 
-		for key, value := range cLabels {
-			if event.Check.ObjectMeta.Labels[key] != value {
-				selected = false
-				break
-			}
-		}
+	if len(state.Percents) >= 2 {
+		result.Trend = math.Round(slope(state.Percents)*100) / 100
+		decline := -result.Trend
 
-		if selected {
-			for key, value := range eLabels {
-				if event.Entity.ObjectMeta.Labels[key] != value {
-					selected = false
-					break
-				}
+		for _, threshold := range []Threshold{
+			{Status: 2, Tripped: critTrend != 0 && decline >= critTrend},
+			{Status: 1, Tripped: warnTrend != 0 && decline >= warnTrend},
+		} {
+			if threshold.Tripped && result.Status < threshold.Status {
+				result.Status = threshold.Status
+				result.Output = fmt.Sprintf("%s: Percent OK is declining by %g%% per run over the last %d runs", statusNames[threshold.Status], decline, len(state.Percents))
+				break
 			}
 		}
-
-		if selected {
-			result = append(result, event)
-		}
 	}
 
-	return result
+	return result, writeState(stateFile, states)
 }
 
-func getEvents(auth Auth, namespace string) ([]*types.Event, error) {
-	url := fmt.Sprintf("%s://%s:%s/api/core/v2/namespaces/%s/events", apiProto, apiHost, apiPort, namespace)
-	events := []*types.Event{}
-
-	req, err := http.NewRequest("GET", url, nil)
+// dampResult only escalates the status of a result when it was returned for
+// --consecutive runs, as recorded in the state file. Lower statuses are
+// reported immediately.
+func dampResult(result Result) (Result, error) {
+	states, err := readState(stateFile)
 	if err != nil {
-		return events, err
+		return result, err
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", auth.AccessToken))
-	req.Header.Set("Content-Type", "application/json")
+	key := stateKey()
+	state, ok := states[key]
+	if !ok {
+		state = &State{}
+		states[key] = state
+	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return events, err
+	if state.Status == result.Status && state.Streak > 0 {
+		state.Streak += 1
+	} else {
+		state.Status = result.Status
+		state.Streak = 1
 	}
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return events, err
+	if result.Status > state.Reported && state.Streak < consecutive {
+		result.Output = fmt.Sprintf("%s: pending %s (%d/%d consecutive runs)", statusNames[state.Reported], result.Output, state.Streak, consecutive)
+		result.Status = state.Reported
+	}
+
+	state.Reported = result.Status
+
+	return result, writeState(stateFile, states)
+}
+
+// sampleEvents reads or fetches the Events to evaluate.
+func sampleEvents(ctx context.Context) ([]*types.Event, map[string]string, error) {
+	var events []*types.Event
+	var err error
+
+	failed := map[string]string{}
+
+	if eventsFile != "" {
+		events, err = readEvents(eventsFile)
+	} else {
+		events, failed, err = fetchEvents(ctx)
 	}
 
-	err = json.Unmarshal(body, &events)
 	if err != nil {
-		return events, err
+		return events, failed, err
 	}
 
-	result := filterEvents(events)
+	if latestOnly {
+		events = latestEvents(events)
+	}
 
-	return result, err
+	return events, failed, nil
 }
 
-func evalAggregate() error {
-	auth, err := authenticate()
+// confirmResult samples the Events again after the --confirm-delay, and
+// downgrades a critical result to warning when the second sample is not
+// critical. The confirmation is skipped when it would exceed the --deadline.
+func confirmResult(ctx context.Context, result Result) Result {
+	if until, ok := ctx.Deadline(); ok && time.Now().Add(confirmDelay).After(until) {
+		notices = append(notices, fmt.Sprintf("the critical status was not confirmed, the --confirm-delay of %s would exceed the --deadline", confirmDelay))
+		return result
+	}
+
+	time.Sleep(confirmDelay)
+
+	eventCache = map[string][]*types.Event{}
+	invalidEvents = 0
+	emptyOutputEvents = 0
+
+	// The notices and filter trace describe the first sample.
+	savedNotices, savedTrace := notices, filterTrace
+	filterTrace = map[string]int{}
+
+	events, _, err := sampleEvents(ctx)
+	notices, filterTrace = savedNotices, savedTrace
+
+	// A second sample cut short by the --deadline does not confirm anything.
+	if err == nil {
+		err = ctx.Err()
+	}
 
 	if err != nil {
-		return err
+		notices = append(notices, fmt.Sprintf("the critical status was not confirmed: %v", err))
+		return result
 	}
 
-	events := []*types.Event{}
+	if evalEvents(events).Status < 2 {
+		result.Output = fmt.Sprintf("%s: unconfirmed %s (not critical after %s)", statusNames[1], result.Output, confirmDelay)
+		result.Status = 1
+	}
 
-	for _, namespace := range strings.Split(namespaces, ",") {
-		selected, err := getEvents(auth, namespace)
+	return result
+}
 
+// evalAggregate evaluates and prints the aggregate, returning its status.
+func evalAggregate() (int, error) {
+	if maintenanceSilence != "" && eventsFile == "" {
+		silenced, err := getMaintenance()
 		if err != nil {
-			return err
+			return 0, err
 		}
 
-		for _, event := range selected {
-			events = append(events, event)
+		if silenced != nil {
+			return printResult(Result{
+				Namespaces: map[string]Counters{},
+				Output:     fmt.Sprintf("OK: Maintenance in progress (%s/%s: %s)", silenced.ObjectMeta.Namespace, silenced.ObjectMeta.Name, silenced.Reason),
+			})
 		}
 	}
 
-	counters := Counters{}
+	notices = []string{}
 
-	entities := map[string]string{}
-	checks := map[string]string{}
+	if eventsFile == "" {
+		time.Sleep(jitterDelay(authJitter))
+	}
 
-	for _, event := range events {
-		entities[event.Entity.ObjectMeta.Name] = ""
-		checks[event.Check.ObjectMeta.Name] = ""
+	ctx := context.Background()
 
-		switch event.Check.Status {
-		case 0:
-			counters.Ok += 1
-		case 1:
-			counters.Warning += 1
-		case 2:
-			counters.Critical += 1
-		default:
-			counters.Unknown += 1
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	events, failed, err := sampleEvents(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	result := evalEvents(events)
+	result.Failed = failed
+	result.Partial = ctx.Err() == context.DeadlineExceeded
+
+	if listHealthy {
+		result.Healthy = healthyEntities(events)
+	}
+
+	if confirmDelay > 0 && result.Status == 2 {
+		result = confirmResult(ctx, result)
+	}
+
+	result.Notices = notices
+
+	if warnTrend != 0 || critTrend != 0 {
+		result, err = trendResult(result)
+		if err != nil {
+			return 0, err
 		}
+	}
 
-		counters.Total += 1
+	if result.Status < minStatus {
+		result.Output = fmt.Sprintf("%s: %s (raised to --min-status)", statusNames[minStatus], result.Output)
+		result.Status = minStatus
 	}
 
-	counters.Entities = len(entities)
-	counters.Checks = len(checks)
+	if consecutive > 1 {
+		result, err = dampResult(result)
+		if err != nil {
+			return 0, err
+		}
+	}
 
-	fmt.Printf("Counters: %+v\n", counters)
+	if classifyErr != nil {
+		return 0, classifyErr
+	}
 
-	if counters.Total == 0 {
-		fmt.Printf("WARNING: No Events returned for Aggregate\n")
-		os.Exit(1)
+	return printResult(result)
+}
+
+// printResult prints the result in the --output format, sends it to the other
+// destinations and returns the exit status.
+func printResult(result Result) (int, error) {
+	var err error
+
+	result.Output, err = renderTemplate(messageTmpl, result)
+	if err != nil {
+		return 0, err
+	}
+
+	output, err := formatResult(result, outputFormat)
+	if err != nil {
+		return 0, err
 	}
 
-	percent := int((float64(counters.Ok) / float64(counters.Total)) * 100)
+	if printPercent {
+		output = formatPercent(result.RawPercent) + "\n"
+	}
 
-	fmt.Printf("Percent OK: %v\n", percent)
+	fmt.Print(output)
 
-	if critPercent != 0 {
-		if percent <= critPercent {
-			fmt.Printf("CRITICAL: Less than %d%% percent OK (%d%%)\n", critPercent, percent)
-			os.Exit(2)
+	if outputFile != "" {
+		if err := writeResult(outputFile, result); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write result to %s: %v\n", outputFile, err)
 		}
 	}
 
-	if warnPercent != 0 {
-		if percent <= warnPercent {
-			fmt.Printf("WARNING: Less than %d%% percent OK (%d%%)\n", warnPercent, percent)
-			os.Exit(1)
+	if pushgatewayUrl != "" {
+		if err := pushMetrics(pushgatewayUrl, result); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to push metrics to the Pushgateway: %v\n", err)
 		}
 	}
 
-	if critCount != 0 {
-		if counters.Critical >= critCount {
-			fmt.Printf("CRITICAL: %d or more Events are in a Critical state (%d)\n", critCount, counters.Critical)
-			os.Exit(2)
+	if useSyslog {
+		if err := writeSyslog(result); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write result to syslog: %v\n", err)
 		}
 	}
 
-	if warnCount != 0 {
-		if counters.Warning >= warnCount {
-			fmt.Printf("WARNING: %d or more Events are in a Warning state (%d)\n", warnCount, counters.Warning)
-			os.Exit(2)
+	if resultWebhook != "" {
+		if err := postResult(resultWebhook, result); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to post result to webhook: %v\n", err)
 		}
 	}
 
-	fmt.Printf("Everything is OK\n")
+	if printPercent {
+		return 0, nil
+	}
 
-	return err
+	return result.Status, nil
 }
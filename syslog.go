@@ -0,0 +1,69 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// syslogFacilities are the facilities accepted by --syslog-facility.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":   syslog.LOG_KERN,
+	"user":   syslog.LOG_USER,
+	"mail":   syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON,
+	"auth":   syslog.LOG_AUTH,
+	"syslog": syslog.LOG_SYSLOG,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// syslogNetwork and syslogAddr are the syslog server to write to, the local
+// syslog when empty.
+var (
+	syslogNetwork string
+	syslogAddr    string
+)
+
+// checkSyslogFacility returns an error for an unknown --syslog-facility.
+func checkSyslogFacility(facility string) error {
+	if _, ok := syslogFacilities[facility]; !ok {
+		return fmt.Errorf("invalid syslog facility %q", facility)
+	}
+
+	return nil
+}
+
+// writeSyslog writes the status line of a result to the local syslog, with a
+// severity by its status.
+func writeSyslog(result Result) error {
+	facility := syslogFacilities[syslogFacility]
+
+	writer, err := syslog.Dial(syslogNetwork, syslogAddr, facility|syslog.LOG_INFO, syslogTag)
+	if err != nil {
+		return err
+	}
+
+	defer writer.Close()
+
+	message := statusLine(result)
+
+	switch result.Status {
+	case 0:
+		return writer.Info(message)
+	case 1:
+		return writer.Warning(message)
+	case 2:
+		return writer.Crit(message)
+	default:
+		return writer.Err(message)
+	}
+}
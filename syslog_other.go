@@ -0,0 +1,15 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+// checkSyslogFacility accepts any facility on platforms without syslog, as
+// --syslog is ignored.
+func checkSyslogFacility(facility string) error {
+	return nil
+}
+
+// writeSyslog is a no-op on platforms without syslog.
+func writeSyslog(result Result) error {
+	return nil
+}
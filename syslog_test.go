@@ -0,0 +1,62 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslog(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	syslogNetwork, syslogAddr = "udp", listener.LocalAddr().String()
+	defer func() {
+		syslogNetwork, syslogAddr = "", ""
+	}()
+
+	receive := func() string {
+		buffer := make([]byte, 4096)
+
+		listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := listener.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("expected a syslog message: %v", err)
+		}
+
+		return string(buffer[:n])
+	}
+
+	for _, test := range []struct {
+		args     []string
+		priority string
+		message  string
+	}{
+		{[]string{"--crit-count", "1"}, "<130>", "CRITICAL 1 or more Events are in a Critical state (1)"},
+		{[]string{"--warn-count", "3"}, "<134>", "OK Everything is OK"},
+	} {
+		checkEvents(t, fleet(0, 2), append([]string{"--syslog", "--syslog-facility", "local0", "--syslog-tag", "aggregate-test"}, test.args...)...)
+
+		message := receive()
+		if !strings.HasPrefix(message, test.priority) || !strings.Contains(message, "aggregate-test[") || !strings.HasSuffix(strings.TrimSpace(message), test.message) {
+			t.Errorf("expected %s%s in syslog, got %q", test.priority, test.message, message)
+		}
+	}
+
+	_, _, err = execute(t, "--check-labels", "aggregate=test", "--events-file", writeEvents(t, fleet(0)...), "--syslog", "--syslog-facility", "bogus")
+	if err == nil || err.Error() != `invalid syslog facility "bogus"` {
+		t.Errorf("expected an invalid syslog facility error, got %v", err)
+	}
+
+	listener.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := listener.ReadFrom(make([]byte, 4096)); err == nil {
+		t.Errorf("expected no syslog message with an invalid facility")
+	}
+}